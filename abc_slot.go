@@ -12,6 +12,7 @@ import (
 type AbcSlot struct {
 	slotName string
 	args     conf.Map
+	pipeline *GoPipeline
 }
 
 func (slf *AbcSlot) Args() conf.Map {
@@ -32,6 +33,19 @@ func (slf *AbcSlot) SetName(name string) {
 	slf.slotName = name
 }
 
+// SetPipeline 由框架内部在初始化前调用，设置插件所属的Pipeline实例。
+func (slf *AbcSlot) SetPipeline(pipeline *GoPipeline) {
+	slf.pipeline = pipeline
+}
+
+// Pipeline 返回插件所属的Pipeline实例。未设置时，返回默认实例。
+func (slf *AbcSlot) Pipeline() *GoPipeline {
+	if nil == slf.pipeline {
+		return SharedRouter()
+	}
+	return slf.pipeline
+}
+
 func (slf *AbcSlot) TagLog(f func() *zerolog.Event) *zerolog.Event {
 	return f().Str("tag", slf.GetName())
 }
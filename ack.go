@@ -0,0 +1,111 @@
+package gopl
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 消息处理确认：消息及其派生的消息全部处理完成后，回调Input设置的确认函数
+//
+
+var (
+	// Router已停止，消息被放弃处理时，确认回调收到的错误
+	ErrPipelineStopped = errors.New("pipeline is stopped")
+	// Output独立队列已满，消息被丢弃时，确认回调收到的错误
+	ErrOutputOverflow = errors.New("output queue is full")
+	// Output被暂停或者禁用，消息未被处理时，确认回调收到的错误
+	ErrOutputPaused = errors.New("output rejected: output is paused")
+)
+
+// 消息确认回调。err为nil表示消息已被所有Filter和Output成功处理；
+// 否则为第一个导致消息处理失败的错误。
+type AckHandler func(err error)
+
+// 确认组。原始消息与Filter返回的消息、重试副本等派生消息共享一个确认组，
+// 组内所有消息都被释放后，回调确认函数。
+type ackGroup struct {
+	handler   AckHandler // Input设置的确认回调，可以为nil
+	observers []AckHandler
+	pending   int64
+
+	mu  *sync.Mutex
+	err error
+}
+
+func (slf *ackGroup) add() {
+	atomic.AddInt64(&slf.pending, 1)
+}
+
+// 记录第一个处理失败的错误
+func (slf *ackGroup) fail(err error) {
+	slf.mu.Lock()
+	if nil == slf.err {
+		slf.err = err
+	}
+	slf.mu.Unlock()
+}
+
+func (slf *ackGroup) done() {
+	if 0 < atomic.AddInt64(&slf.pending, -1) {
+		return
+	}
+	slf.mu.Lock()
+	err, observers := slf.err, slf.observers
+	slf.mu.Unlock()
+	if nil != slf.handler {
+		slf.handler(err)
+	}
+	for _, observer := range observers {
+		observer(err)
+	}
+}
+
+// SetAckHandler 设置消息处理完成的确认回调，需要在投递消息之前设置。
+// 消息被拒绝、超时、Filter或者Output处理失败时，回调收到错误；被Filter丢弃的消息视为处理成功。
+// 回调在Router的处理协程中执行，不可阻塞。
+func (slf *DataFrame) SetAckHandler(handler AckHandler) {
+	if nil == handler {
+		return
+	}
+	slf.ack = &ackGroup{
+		handler: handler,
+		pending: 1,
+		mu:      new(sync.Mutex),
+	}
+}
+
+// OnComplete 注册消息处理完成的回调，回调参数与确认回调相同。
+// 消息未设置确认回调时，从当前开始跟踪此消息及之后派生的消息。Filter可以在消息处理结果确定后更新自己的状态。
+func (slf *DataFrame) OnComplete(observer AckHandler) {
+	if nil == observer {
+		return
+	}
+	if nil == slf.ack {
+		slf.ack = &ackGroup{
+			pending: 1,
+			mu:      new(sync.Mutex),
+		}
+	}
+	slf.ack.mu.Lock()
+	slf.ack.observers = append(slf.ack.observers, observer)
+	slf.ack.mu.Unlock()
+}
+
+// 派生的消息加入原消息的确认组
+func (slf *DataFrame) inheritAck(from *DataFrame) {
+	if nil == from.ack || nil != slf.ack {
+		return
+	}
+	from.ack.add()
+	slf.ack = from.ack
+}
+
+// 标记消息处理失败
+func (slf *DataFrame) fail(err error) {
+	if nil != slf.ack {
+		slf.ack.fail(err)
+	}
+}
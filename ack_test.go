@@ -0,0 +1,120 @@
+package gopl
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 记录确认回调的结果
+type testAckRecorder struct {
+	mu   sync.Mutex
+	acks []error
+}
+
+func (slf *testAckRecorder) handler(err error) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.acks = append(slf.acks, err)
+}
+
+func (slf *testAckRecorder) results() []error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	return append([]error{}, slf.acks...)
+}
+
+func TestDataFrame_AckAfterFilters(t *testing.T) {
+	router, output := newTestRouter(FilterModeFanout, "decode", "enrich")
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	router.deliver0(pack)
+	if 3 != len(output.records) {
+		t.Fatalf("Output records not match, was: %v", output.records)
+	}
+	if acks := recorder.results(); 1 != len(acks) || nil != acks[0] {
+		t.Fatalf("Should ack once after all frames handled, was: %v", acks)
+	}
+}
+
+func TestDataFrame_NackOnOutputFailure(t *testing.T) {
+	router := newRouter(1)
+	failed := new(testFailOutput)
+	failed.SetName("FailOutput")
+	router.outputRunners.PushBack(newOutputRunner(failed, new(AnyMatcher), &ComponentConfig{}, "FailOutput"))
+	router.buildRouteTable()
+
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	router.deliver0(pack)
+	if acks := recorder.results(); 1 != len(acks) || nil == acks[0] || "broker unavailable" != acks[0].Error() {
+		t.Fatalf("Should nack with output error, was: %v", acks)
+	}
+}
+
+func TestDataFrame_AckAfterOutputQueue(t *testing.T) {
+	router := newRouter(1)
+	output := &testBlockOutput{release: make(chan struct{})}
+	output.SetName("TestBlockOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{QueueSize: 4}, "TestBlockOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	pack.SetDeadline(time.Now().Add(time.Hour))
+	router.deliver0(pack)
+	if 0 != len(recorder.results()) {
+		t.Fatal("Should not ack before output queue handled")
+	}
+	close(output.release)
+	runner.queue.close()
+	if acks := recorder.results(); 1 != len(acks) || nil != acks[0] {
+		t.Fatalf("Should ack after output queue handled, was: %v", acks)
+	}
+}
+
+func TestDataFrame_NackOnReject(t *testing.T) {
+	router := newRouter(1)
+	router.ingress = newIngress(RouterConfig{Backpressure: BackpressureReject, MaxPending: 1}, router.fio, false)
+	recorder := new(testAckRecorder)
+	for i := 0; i < 2; i++ {
+		pack := NewDataFrame()
+		pack.SetAckHandler(recorder.handler)
+		router.TryDeliver(pack)
+	}
+	if acks := recorder.results(); 1 != len(acks) || !errors.Is(acks[0], ErrDeliverRejected) {
+		t.Fatalf("Rejected frame should be nacked, was: %v", acks)
+	}
+}
+
+func TestDataFrame_OnComplete(t *testing.T) {
+	// 未设置确认回调的消息
+	observer := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.OnComplete(observer.handler)
+	pack.fail(ErrOutputOverflow)
+	releaseDataFrame(pack)
+	if acks := observer.results(); 1 != len(acks) || ErrOutputOverflow != acks[0] {
+		t.Fatalf("Observer should receive error, was: %v", acks)
+	}
+
+	// 与确认回调共享确认组
+	recorder, observer := new(testAckRecorder), new(testAckRecorder)
+	pack = NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	pack.OnComplete(observer.handler)
+	releaseDataFrame(pack)
+	if 1 != len(recorder.results()) || 1 != len(observer.results()) || nil != observer.results()[0] {
+		t.Fatalf("Both handler and observer should be called, ack: %v, observer: %v", recorder.results(), observer.results())
+	}
+}
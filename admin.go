@@ -0,0 +1,404 @@
+package gopl
+
+import (
+	"container/list"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"github.com/parkingwang/go-conf"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 管理接口：查看运行中的组件，暂停、恢复和停止组件
+//
+
+const adminTokenHeader = "X-Admin-Token"
+
+// 管理接口操作的组件不存在时返回的错误
+var ErrComponentNotFound = errors.New("component not found")
+
+// 管理接口配置选项。
+// 它对应着配置文件的 [Admin] 配置项，address 为空时不启动管理接口。
+type AdminConfig struct {
+	Address string `toml:"address"` // 管理接口的监听地址，例如 127.0.0.1:9090
+	Token   string `toml:"token"`   // 访问令牌。非空时，请求需要在Header X-Admin-Token 中携带此令牌；为空时只监听本机地址
+}
+
+// 管理接口返回的组件信息
+type ComponentInfo struct {
+	ConfigKey string `json:"config_key"`        // 组件配置名
+	Kind      string `json:"kind"`              // 组件类别：input/filter/output
+	Component string `json:"component"`         // 组件类型名称
+	Name      string `json:"name"`              // 组件实例名称
+	Topic     string `json:"topic"`             // Topic配置
+	Matcher   string `json:"matcher,omitempty"` // Filter/Output的Matcher
+	Decoder   string `json:"decoder,omitempty"` // Input的Decoder
+	State     string `json:"state"`             // 运行状态：running/paused/disabled
+	Handled   uint64 `json:"handled"`
+	Errors    uint64 `json:"errors"`
+	Dropped   uint64 `json:"dropped"`
+	Retries   uint64 `json:"retries"`
+	Throttled uint64 `json:"throttled"`
+	Spooled   uint64 `json:"spooled,omitempty"` // 写入Output磁盘暂存的消息数量
+	Circuit   string `json:"circuit,omitempty"` // Output熔断器状态
+}
+
+type adminServer struct {
+	pipeline *GoPipeline
+	config   AdminConfig
+	server   *http.Server
+}
+
+func newAdminServer(pipeline *GoPipeline, config AdminConfig) *adminServer {
+	// 未配置访问令牌时，只允许本机访问
+	if "" == config.Token && "" != config.Address {
+		if address := loopbackAddress(config.Address); address != config.Address {
+			withTag(log.Warn).Msgf("Admin token is not set, listen on loopback address: %s", address)
+			config.Address = address
+		}
+	}
+	admin := &adminServer{
+		pipeline: pipeline,
+		config:   config,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/components", admin.handleComponents)
+	mux.HandleFunc("/components/", admin.handleComponentAction)
+	mux.HandleFunc("/registry", admin.handleRegistry)
+	mux.HandleFunc("/reload", admin.handleReload)
+	admin.server = &http.Server{
+		Addr:    config.Address,
+		Handler: admin.authorized(mux),
+	}
+	return admin
+}
+
+// 将监听地址的Host替换为本机回环地址。已是回环地址时不变。
+func loopbackAddress(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if nil != err {
+		return address
+	}
+	if "localhost" == host {
+		return address
+	}
+	if ip := net.ParseIP(host); nil != ip && ip.IsLoopback() {
+		return address
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func (slf *adminServer) start() {
+	listener, err := net.Listen("tcp", slf.config.Address)
+	if nil != err {
+		withTag(log.Error).Err(err).Msgf("Admin server listen FAILED: %s", slf.config.Address)
+		return
+	}
+	withTag(log.Info).Msgf("Admin server listen: %s", listener.Addr())
+	go func() {
+		if err := slf.server.Serve(listener); nil != err && http.ErrServerClosed != err {
+			withTag(log.Error).Err(err).Msg("Admin server STOPPED")
+		}
+	}()
+}
+
+func (slf *adminServer) close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if err := slf.server.Shutdown(ctx); nil != err {
+		withTag(log.Warn).Err(err).Msg("Admin server shutdown")
+	}
+}
+
+func (slf *adminServer) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		// 使用固定时间比较，避免通过响应时间猜测Token
+		if "" != slf.config.Token &&
+			1 != subtle.ConstantTimeCompare([]byte(req.Header.Get(adminTokenHeader)), []byte(slf.config.Token)) {
+			writeAdminJSON(resp, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// GET /components
+func (slf *adminServer) handleComponents(resp http.ResponseWriter, req *http.Request) {
+	if http.MethodGet != req.Method {
+		writeAdminJSON(resp, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeAdminJSON(resp, http.StatusOK, slf.pipeline.Components())
+}
+
+// POST /components/{config_key}/pause|resume|disable
+func (slf *adminServer) handleComponentAction(resp http.ResponseWriter, req *http.Request) {
+	if http.MethodPost != req.Method {
+		writeAdminJSON(resp, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/components/")
+	idx := strings.LastIndex(path, "/")
+	if idx <= 0 {
+		writeAdminJSON(resp, http.StatusNotFound, map[string]string{"error": "unknown path"})
+		return
+	}
+	configKey, action := path[:idx], path[idx+1:]
+	var err error
+	switch action {
+	case "pause":
+		err = slf.pipeline.PauseComponent(configKey)
+	case "resume":
+		err = slf.pipeline.ResumeComponent(configKey)
+	case "disable":
+		err = slf.pipeline.DisableComponent(configKey)
+	default:
+		writeAdminJSON(resp, http.StatusNotFound, map[string]string{"error": "unknown action: " + action})
+		return
+	}
+	if ErrComponentNotFound == errors.Cause(err) {
+		writeAdminJSON(resp, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	} else if nil != err {
+		writeAdminJSON(resp, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	withTag(log.Info).Msgf("Admin: %s component <%s>", action, configKey)
+	for _, info := range slf.pipeline.Components() {
+		if configKey == info.ConfigKey {
+			writeAdminJSON(resp, http.StatusOK, info)
+			return
+		}
+	}
+	writeAdminJSON(resp, http.StatusOK, map[string]string{"config_key": configKey})
+}
+
+// GET /registry
+func (slf *adminServer) handleRegistry(resp http.ResponseWriter, req *http.Request) {
+	if http.MethodGet != req.Method {
+		writeAdminJSON(resp, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	writeAdminJSON(resp, http.StatusOK, slf.pipeline.Registry())
+}
+
+// POST /reload
+func (slf *adminServer) handleReload(resp http.ResponseWriter, req *http.Request) {
+	if http.MethodPost != req.Method {
+		writeAdminJSON(resp, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	if err := slf.pipeline.Reload(); nil != err {
+		writeAdminJSON(resp, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeAdminJSON(resp, http.StatusOK, map[string]string{"status": "reloaded"})
+}
+
+func writeAdminJSON(resp http.ResponseWriter, status int, data interface{}) {
+	body, err := MarshalJSON(data)
+	if nil != err {
+		withTag(log.Error).Err(err).Msg("Admin: encode response FAILED")
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(status)
+	resp.Write(body)
+}
+
+////
+
+// Components 返回当前运行中的组件信息，按 Input、Filter、Output 的顺序排列
+func (slf *GoPipeline) Components() []ComponentInfo {
+	slf.reloadMu.Lock()
+	defer slf.reloadMu.Unlock()
+	out := make([]ComponentInfo, 0)
+	for _, runners := range []*list.List{slf.inputRunners, slf.filterRunners, slf.outputRunners} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			out = append(out, newComponentInfo(ele.Value))
+		}
+	}
+	return out
+}
+
+// Registry 返回实例中已注册的组件类型、Decoder和Matcher名称
+func (slf *GoPipeline) Registry() map[string][]string {
+	inputs := make([]string, 0, len(slf.factoryInputs))
+	for name := range slf.factoryInputs {
+		inputs = append(inputs, name)
+	}
+	filters := make([]string, 0, len(slf.factoryFilters))
+	for name := range slf.factoryFilters {
+		filters = append(filters, name)
+	}
+	outputs := make([]string, 0, len(slf.factoryOutputs))
+	for name := range slf.factoryOutputs {
+		outputs = append(outputs, name)
+	}
+	decoders := make([]string, 0, len(slf.decoders))
+	for name := range slf.decoders {
+		decoders = append(decoders, name)
+	}
+	matchers := make([]string, 0, len(slf.matchers))
+	for name := range slf.matchers {
+		matchers = append(matchers, name)
+	}
+	for _, names := range [][]string{inputs, filters, outputs, decoders, matchers} {
+		sort.Strings(names)
+	}
+	return map[string][]string{
+		"inputs":   inputs,
+		"filters":  filters,
+		"outputs":  outputs,
+		"decoders": decoders,
+		"matchers": matchers,
+	}
+}
+
+// PauseComponent 暂停组件。暂停的Input拒绝投递消息，暂停的Filter和Output跳过消息。
+func (slf *GoPipeline) PauseComponent(configKey string) error {
+	state, err := slf.componentStateOf(configKey)
+	if nil != err {
+		return err
+	}
+	if state.isDisabled() {
+		return fmt.Errorf("component is disabled: %s", configKey)
+	}
+	state.setPaused(true)
+	return nil
+}
+
+// ResumeComponent 恢复暂停的组件
+func (slf *GoPipeline) ResumeComponent(configKey string) error {
+	state, err := slf.componentStateOf(configKey)
+	if nil != err {
+		return err
+	}
+	if state.isDisabled() {
+		return fmt.Errorf("component is disabled: %s", configKey)
+	}
+	state.setPaused(false)
+	return nil
+}
+
+// DisableComponent 停止组件。停止的组件不再处理消息，在重新加载配置时被重新创建。
+func (slf *GoPipeline) DisableComponent(configKey string) error {
+	slf.reloadMu.Lock()
+	defer slf.reloadMu.Unlock()
+	runner := slf.findRunner(configKey)
+	if nil == runner {
+		return errors.WithMessage(ErrComponentNotFound, configKey)
+	}
+	if runnerDisabled(runner) {
+		return nil
+	}
+	// 先暂停，停止接收新的消息
+	runnerState(runner).setPaused(true)
+	closeRunner(runner)
+	return nil
+}
+
+func (slf *GoPipeline) componentStateOf(configKey string) (*componentState, error) {
+	slf.reloadMu.Lock()
+	defer slf.reloadMu.Unlock()
+	runner := slf.findRunner(configKey)
+	if nil == runner {
+		return nil, errors.WithMessage(ErrComponentNotFound, configKey)
+	}
+	return runnerState(runner), nil
+}
+
+// 读取 [Admin] 配置，并创建管理接口。未配置监听地址时不创建。
+func (slf *GoPipeline) setupAdmin() {
+	admin := slf.rootConfig.MustMap("Admin")
+	if len(admin) == 0 {
+		return
+	}
+	config := AdminConfig{}
+	if err := conf.Map2Struct(admin, &config); nil != err {
+		withTag(log.Panic).Err(err).Msg("Failed to decode map to [Admin] config")
+	}
+	if "" != config.Address {
+		slf.admin = newAdminServer(slf, config)
+	}
+}
+
+func runnerState(runner interface{}) *componentState {
+	switch r := runner.(type) {
+	case *inputRunner:
+		return r.componentState
+	case *filterRunner:
+		return r.componentState
+	case *outputRunner:
+		return r.componentState
+	default:
+		return nil
+	}
+}
+
+func newComponentInfo(runner interface{}) ComponentInfo {
+	typeName := func(v interface{}) string {
+		if s, ok := v.(fmt.Stringer); ok {
+			return s.String()
+		}
+		return fmt.Sprintf("%T", v)
+	}
+	switch r := runner.(type) {
+	case *inputRunner:
+		return ComponentInfo{
+			ConfigKey: r.configKey,
+			Kind:      "input",
+			Component: r.config.ComponentType,
+			Name:      r.input.GetName(),
+			Topic:     r.config.Topic,
+			Decoder:   fmt.Sprintf("%T", r.decoder),
+			State:     r.status(),
+		}
+	case *filterRunner:
+		return ComponentInfo{
+			ConfigKey: r.configKey,
+			Kind:      "filter",
+			Component: r.config.ComponentType,
+			Name:      r.filter.GetName(),
+			Topic:     r.config.Topic,
+			Matcher:   typeName(r.matcher),
+			State:     r.status(),
+			Handled:   r.counter.Handled(),
+			Errors:    r.counter.Errors(),
+			Dropped:   r.counter.Dropped(),
+		}
+	case *outputRunner:
+		// Output丢弃的消息，由独立队列溢出时计数
+		var dropped uint64
+		if nil != r.queue {
+			dropped = r.queue.counter.Dropped()
+		}
+		return ComponentInfo{
+			ConfigKey: r.configKey,
+			Kind:      "output",
+			Component: r.config.ComponentType,
+			Name:      r.output.GetName(),
+			Topic:     r.config.Topic,
+			Matcher:   typeName(r.matcher),
+			State:     r.status(),
+			Handled:   r.counter.Handled(),
+			Errors:    r.counter.Errors(),
+			Dropped:   dropped,
+			Retries:   r.counter.Retries(),
+			Throttled: r.counter.Throttled(),
+			Spooled:   r.counter.Spooled(),
+			Circuit:   r.counter.CircuitState(),
+		}
+	default:
+		return ComponentInfo{}
+	}
+}
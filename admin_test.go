@@ -0,0 +1,120 @@
+package gopl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func TestAdmin_PauseResume(t *testing.T) {
+	router, output := newTestRouter(FilterModeChain, "decode", "enrich")
+	handler := newAdminServer(router, AdminConfig{Token: "secret"}).server.Handler
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if "" != token {
+			req.Header.Set(adminTokenHeader, token)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := request("GET", "/components", ""); http.StatusUnauthorized != resp.Code {
+		t.Fatalf("Request without token should be rejected, was: %d", resp.Code)
+	}
+	if resp := request("GET", "/components", "secre"); http.StatusUnauthorized != resp.Code {
+		t.Fatalf("Request with wrong token should be rejected, was: %d", resp.Code)
+	}
+
+	resp := request("GET", "/components", "secret")
+	infos := make([]ComponentInfo, 0)
+	if err := json.Unmarshal(resp.Body.Bytes(), &infos); nil != err {
+		t.Fatal(err)
+	}
+	if 3 != len(infos) || "filter" != infos[0].Kind || "output" != infos[2].Kind ||
+		"AnyMatcher" != infos[2].Matcher || ComponentRunning != infos[0].State {
+		t.Fatalf("Components not match, was: %+v", infos)
+	}
+
+	if resp := request("POST", "/components/enrich/pause", "secret"); http.StatusOK != resp.Code {
+		t.Fatalf("Pause failed: %s", resp.Body.String())
+	}
+	router.deliver0(NewDataFrame())
+	if 1 != len(output.records) || "/decode" != output.records[0] {
+		t.Fatalf("Paused filter should pass frames through, was: %v", output.records)
+	}
+
+	if resp := request("POST", "/components/TestRecordOutput/pause", "secret"); http.StatusOK != resp.Code {
+		t.Fatalf("Pause failed: %s", resp.Body.String())
+	}
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	router.deliver0(pack)
+	if 1 != len(output.records) {
+		t.Fatalf("Paused output should not handle frames, was: %v", output.records)
+	}
+	if acks := recorder.results(); 1 != len(acks) || ErrOutputPaused != acks[0] {
+		t.Fatalf("Paused output should fail frames, was: %v", acks)
+	}
+	request("POST", "/components/TestRecordOutput/resume", "secret")
+
+	request("POST", "/components/enrich/resume", "secret")
+	router.deliver0(NewDataFrame())
+	if 2 != len(output.records) || "/decode/enrich" != output.records[1] {
+		t.Fatalf("Resumed filter should handle frames, was: %v", output.records)
+	}
+
+	if resp := request("POST", "/components/TestRecordOutput/disable", "secret"); http.StatusOK != resp.Code {
+		t.Fatalf("Disable failed: %s", resp.Body.String())
+	}
+	router.deliver0(NewDataFrame())
+	if 2 != len(output.records) {
+		t.Fatalf("Disabled output should not handle frames, was: %v", output.records)
+	}
+	if resp := request("POST", "/components/TestRecordOutput/resume", "secret"); http.StatusConflict != resp.Code {
+		t.Fatalf("Disabled component can not be resumed, was: %d", resp.Code)
+	}
+	if resp := request("POST", "/components/unknown/pause", "secret"); http.StatusNotFound != resp.Code {
+		t.Fatalf("Unknown component should fail, was: %d", resp.Code)
+	}
+}
+
+func TestAdmin_OutputDropped(t *testing.T) {
+	output := new(testRecordOutput)
+	output.SetName("QueueOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{QueueSize: 1, Overflow: OverflowDropNew}, "QueueOutput")
+	runner.queue.start(func(pack *DataFrame) {}, func(pack *DataFrame) {
+		releaseDataFrame(pack)
+	})
+	defer runner.queue.close()
+	runner.queue.counter.increaseDropped()
+	if info := newComponentInfo(runner); 1 != info.Dropped {
+		t.Fatalf("Output dropped should come from queue, was: %d", info.Dropped)
+	}
+}
+
+func TestAdmin_LoopbackWithoutToken(t *testing.T) {
+	for address, expected := range map[string]string{
+		":9090":          "127.0.0.1:9090",
+		"0.0.0.0:9090":   "127.0.0.1:9090",
+		"127.0.0.1:9090": "127.0.0.1:9090",
+		"localhost:9090": "localhost:9090",
+		"[::1]:9090":     "[::1]:9090",
+	} {
+		admin := newAdminServer(newRouter(1), AdminConfig{Address: address})
+		if expected != admin.config.Address || expected != admin.server.Addr {
+			t.Fatalf("Address %s should listen on: %s, was: %s", address, expected, admin.config.Address)
+		}
+	}
+	admin := newAdminServer(newRouter(1), AdminConfig{Address: ":9090", Token: "secret"})
+	if ":9090" != admin.config.Address {
+		t.Fatalf("Address with token should not change, was: %s", admin.config.Address)
+	}
+}
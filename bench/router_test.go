@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/rs/zerolog"
+	"github.com/yoojia/go-pipeline"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Router吞吐量测试：64个按Topic匹配的Output，以及2个匹配所有消息的Output。
+//
+
+const (
+	benchExactOutputs = 64
+	benchAnyOutputs   = 2
+	benchTopic        = "/bench/topic/32"
+)
+
+var (
+	gBenchFrames = make(chan struct{})
+	gBenchWaits  = new(sync.WaitGroup)
+)
+
+type BenchInput struct {
+	gopl.AbcSlot
+}
+
+func (slf *BenchInput) Input(deliverer gopl.Deliverer, decoder gopl.Decoder) {
+	for range gBenchFrames {
+		if pack, err := decoder.Decode([]byte(`{"bench":true}`)); nil == err {
+			deliverer.Deliver(pack)
+		}
+	}
+}
+
+type BenchOutput struct {
+	gopl.AbcSlot
+}
+
+func (slf *BenchOutput) Output(pack *gopl.DataFrame) {
+	gBenchWaits.Done()
+}
+
+func TestMain(m *testing.M) {
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	dir, err := ioutil.TempDir("", "gopl-bench")
+	if nil != err {
+		panic(err)
+	}
+
+	config := new(bytes.Buffer)
+	config.WriteString("[Debug]\n  block_detect_time = \"1s\"\n\n")
+	config.WriteString(fmt.Sprintf("[BenchInput]\n  topic = \"%s\"\n\n", benchTopic))
+	for i := 0; i < benchExactOutputs; i++ {
+		config.WriteString(fmt.Sprintf("[BenchOutput%d]\n  component = \"BenchOutput\"\n  topic = \"/bench/topic/%d\"\n\n", i, i))
+	}
+	for i := 0; i < benchAnyOutputs; i++ {
+		config.WriteString(fmt.Sprintf("[BenchAnyOutput%d]\n  component = \"BenchOutput\"\n  topic = \"*\"\n\n", i))
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bench.toml"), config.Bytes(), 0644); nil != err {
+		panic(err)
+	}
+
+	router := gopl.New(gopl.Options{})
+	router.Prepare(func(r *gopl.GoPipeline) {
+		r.AutoRegister(new(BenchInput))
+		r.AutoRegister(new(BenchOutput))
+	})
+	router.Setup(dir)
+	router.Init()
+	os.RemoveAll(dir)
+	go router.StartRoute()
+
+	code := m.Run()
+	close(gBenchFrames)
+	router.StopRouter()
+	os.Exit(code)
+}
+
+// 每个消息匹配1个Topic Output和2个通配Output
+func BenchmarkRouter_Outputs(b *testing.B) {
+	gBenchWaits.Add(b.N * (1 + benchAnyOutputs))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		gBenchFrames <- struct{}{}
+	}
+	gBenchWaits.Wait()
+}
@@ -0,0 +1,166 @@
+package gopl
+
+import (
+	"errors"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Output熔断器：下游持续失败时，快速放弃处理消息
+//
+
+// 熔断器状态
+const (
+	CircuitClosed   = "closed"    // 正常处理消息
+	CircuitOpen     = "open"      // 熔断中，消息被快速放弃
+	CircuitHalfOpen = "half_open" // 熔断时间结束，允许少量消息探测下游是否恢复
+)
+
+// 熔断中的Output放弃处理消息，投递死信消息时记录的错误
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Output熔断器配置选项。它对应着组件配置的 [X.CircuitBreaker] 配置项。
+type CircuitBreakerConfig struct {
+	ConsecutiveFailures int     `toml:"consecutive_failures"` // 连续失败次数达到此值时熔断，默认5。0表示不按连续失败熔断
+	FailureRatio        float64 `toml:"failure_ratio"`        // 统计周期内失败比例达到此值时熔断，0~1。0表示不按失败比例熔断
+	MinRequests         int     `toml:"min_requests"`         // 按失败比例熔断时，统计周期内的最少处理次数，默认20
+	Window              string  `toml:"window"`               // 失败比例的统计周期，默认10s
+	OpenDuration        string  `toml:"open_duration"`        // 熔断持续时间，默认30s
+	HalfOpenProbes      int     `toml:"half_open_probes"`     // 熔断结束后，连续成功此数量的探测消息才恢复，默认1
+	Fallback            string  `toml:"fallback"`             // 熔断中消息的处理方式：dead_letter(默认)/skip
+}
+
+type circuitBreaker struct {
+	name    string
+	counter *ComponentCounter
+
+	consecutiveFailures int
+	failureRatio        float64
+	minRequests         int
+	window              time.Duration
+	openDuration        time.Duration
+	halfOpenProbes      int
+	deadLetter          bool // 熔断中的消息是否投递到死信Topic
+
+	mu          *sync.Mutex
+	state       string
+	failures    int       // 连续失败次数
+	total       int       // 统计周期内的处理次数
+	failed      int       // 统计周期内的失败次数
+	windowStart time.Time // 统计周期开始时间
+	openUntil   time.Time // 熔断结束时间
+	probing     int       // 半开状态下，处理中的探测消息数量
+	probed      int       // 半开状态下，成功的探测消息数量
+}
+
+// 根据配置创建熔断器。未配置时返回nil。
+func newCircuitBreaker(name string, config *CircuitBreakerConfig, counter *ComponentCounter) *circuitBreaker {
+	if nil == config {
+		return nil
+	}
+	cb := &circuitBreaker{
+		name:                name,
+		counter:             counter,
+		consecutiveFailures: config.ConsecutiveFailures,
+		failureRatio:        config.FailureRatio,
+		minRequests:         config.MinRequests,
+		window:              DurationOrDefault(config.Window, time.Second*10),
+		openDuration:        DurationOrDefault(config.OpenDuration, time.Second*30),
+		halfOpenProbes:      config.HalfOpenProbes,
+		deadLetter:          "skip" != config.Fallback,
+		mu:                  new(sync.Mutex),
+		state:               CircuitClosed,
+		windowStart:         time.Now(),
+	}
+	if 0 == cb.consecutiveFailures && 0 >= cb.failureRatio {
+		cb.consecutiveFailures = 5
+	}
+	if 0 >= cb.minRequests {
+		cb.minRequests = 20
+	}
+	if 0 >= cb.halfOpenProbes {
+		cb.halfOpenProbes = 1
+	}
+	counter.setCircuitState(CircuitClosed)
+	return cb
+}
+
+// 返回是否允许处理消息。熔断时间结束后转为半开状态，只允许有限数量的探测消息。
+func (slf *circuitBreaker) allow() bool {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	switch slf.state {
+	case CircuitOpen:
+		if time.Now().Before(slf.openUntil) {
+			return false
+		}
+		slf.transit(CircuitHalfOpen)
+		fallthrough
+	case CircuitHalfOpen:
+		if slf.probing+slf.probed >= slf.halfOpenProbes {
+			return false
+		}
+		slf.probing++
+		return true
+	default:
+		return true
+	}
+}
+
+// 记录消息处理结果
+func (slf *circuitBreaker) done(err error) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	now := time.Now()
+	switch slf.state {
+	case CircuitHalfOpen:
+		slf.probing--
+		if nil != err {
+			slf.open(now)
+		} else if slf.probed++; slf.probed >= slf.halfOpenProbes {
+			slf.transit(CircuitClosed)
+		}
+
+	case CircuitClosed:
+		if now.Sub(slf.windowStart) >= slf.window {
+			slf.total, slf.failed, slf.windowStart = 0, 0, now
+		}
+		slf.total++
+		if nil == err {
+			slf.failures = 0
+			return
+		}
+		slf.failures++
+		slf.failed++
+		if 0 < slf.consecutiveFailures && slf.failures >= slf.consecutiveFailures {
+			slf.open(now)
+		} else if 0 < slf.failureRatio && slf.total >= slf.minRequests &&
+			float64(slf.failed)/float64(slf.total) >= slf.failureRatio {
+			slf.open(now)
+		}
+	}
+	// Open状态下仍在处理的消息，其结果不影响状态
+}
+
+func (slf *circuitBreaker) open(now time.Time) {
+	slf.openUntil = now.Add(slf.openDuration)
+	slf.transit(CircuitOpen)
+}
+
+func (slf *circuitBreaker) transit(state string) {
+	slf.state = state
+	slf.failures, slf.total, slf.failed, slf.windowStart = 0, 0, 0, time.Now()
+	slf.probing, slf.probed = 0, 0
+	slf.counter.setCircuitState(state)
+	switch state {
+	case CircuitOpen:
+		withTag(log.Warn).Msgf("Output: <%s> circuit OPEN, until: %s", slf.name, slf.openUntil.Format("15:04:05.000"))
+	case CircuitHalfOpen:
+		withTag(log.Info).Msgf("Output: <%s> circuit HALF-OPEN, probes: %d", slf.name, slf.halfOpenProbes)
+	default:
+		withTag(log.Info).Msgf("Output: <%s> circuit CLOSED", slf.name)
+	}
+}
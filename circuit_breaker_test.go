@@ -0,0 +1,112 @@
+package gopl
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	counter := newComponentCounter("Output")
+	cb := newCircuitBreaker("Output", &CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenDuration:        "50ms",
+		HalfOpenProbes:      2,
+	}, counter)
+	failed := errors.New("failed")
+
+	for i := 0; i < 3; i++ {
+		if !cb.allow() {
+			t.Fatalf("Closed circuit should allow, at: %d", i)
+		}
+		cb.done(failed)
+	}
+	if CircuitOpen != counter.CircuitState() || cb.allow() {
+		t.Fatalf("Circuit should be open, was: %s", counter.CircuitState())
+	}
+
+	// 熔断结束，半开状态只允许2个探测消息
+	time.Sleep(time.Millisecond * 60)
+	if !cb.allow() || !cb.allow() || cb.allow() {
+		t.Fatal("Half-open circuit should allow 2 probes")
+	}
+	if CircuitHalfOpen != counter.CircuitState() {
+		t.Fatalf("Circuit should be half-open, was: %s", counter.CircuitState())
+	}
+	cb.done(nil)
+	cb.done(failed)
+	if CircuitOpen != counter.CircuitState() {
+		t.Fatalf("Failed probe should open circuit, was: %s", counter.CircuitState())
+	}
+
+	time.Sleep(time.Millisecond * 60)
+	cb.allow()
+	cb.allow()
+	cb.done(nil)
+	cb.done(nil)
+	if CircuitClosed != counter.CircuitState() || !cb.allow() {
+		t.Fatalf("Circuit should be closed, was: %s", counter.CircuitState())
+	}
+}
+
+func TestCircuitBreaker_FailureRatio(t *testing.T) {
+	counter := newComponentCounter("Output")
+	cb := newCircuitBreaker("Output", &CircuitBreakerConfig{
+		FailureRatio: 0.5,
+		MinRequests:  10,
+	}, counter)
+	// 交替成功和失败，连续失败不会达到阈值
+	for i := 0; i < 9; i++ {
+		cb.allow()
+		if 0 == i%2 {
+			cb.done(errors.New("failed"))
+		} else {
+			cb.done(nil)
+		}
+	}
+	if CircuitClosed != counter.CircuitState() {
+		t.Fatal("Circuit should be closed before min requests")
+	}
+	cb.allow()
+	cb.done(errors.New("failed"))
+	if CircuitOpen != counter.CircuitState() {
+		t.Fatalf("Circuit should be open by failure ratio, was: %s", counter.CircuitState())
+	}
+}
+
+func TestRouter_CircuitBreakerDeadLetter(t *testing.T) {
+	router := newRouter(1)
+	router.routerConfig.DeadLetterTopic = "/dlq"
+	output := &testFlakyOutput{failures: 100, err: errors.New("connection refused")}
+	config := &ComponentConfig{CircuitBreaker: &CircuitBreakerConfig{ConsecutiveFailures: 2}}
+	matcher, err := NewDefaultURLMatcher("/data")
+	if nil != err {
+		t.Fatal(err)
+	}
+	runner := newOutputRunner(output, matcher, config, "FlakyOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.drainTimeout = time.Second
+	router.threads.Start()
+	defer router.threads.Shutdown()
+
+	for i := 0; i < 5; i++ {
+		pack := NewDataFrame()
+		pack.setTopic("/data")
+		router.output0(runner, pack)
+	}
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain dead letters, dropped: %d", dropped)
+	}
+	if 2 != output.attempts || 3 != runner.counter.ShortCircuited() {
+		t.Fatalf("Open circuit should skip output, attempts: %d, short-circuited: %d",
+			output.attempts, runner.counter.ShortCircuited())
+	}
+	if 5 != router.fio.DeadLetters() {
+		t.Fatalf("Failed and short-circuited frames should be dead letters, was: %d", router.fio.DeadLetters())
+	}
+}
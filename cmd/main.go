@@ -134,10 +134,11 @@ func main() {
 		r.AutoRegister(new(common.GoPLConsoleOutput))
 		r.AutoRegister(new(common.GoPLProcMemInfoDecoder))
 		r.AutoRegister(new(common.GoPLFilePollingInput))
+		r.AutoRegister(new(common.GoPLDedupeFilter))
 
 		// http
-		r.RegisterStartupHook(http.ServerStartupHook)
-		r.RegisterTerminateHook(http.ServerTerminateHook)
+		r.RegisterStartupHook(http.NewServerStartupHook(r))
+		r.RegisterTerminateHook(http.NewServerTerminateHook(r))
 		r.AutoRegister(new(http.GoPLHttpServerInput))
 		r.AutoRegister(new(http.GoPLWebSocketClientInput))
 		r.AutoRegister(new(http.GoPLWebSocketServerOutput))
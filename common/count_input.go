@@ -31,12 +31,20 @@ func (slf *GoPLDeliverCountInput) Input(deliverer gopl.Deliverer, decoder gopl.D
 	inboundCount := uint64(0)
 	filtersCount := uint64(0)
 	outputsCount := uint64(0)
+	expiredCount := uint64(0)
+	rejectedCount := uint64(0)
+	spilledCount := uint64(0)
+	deadLetterCount := uint64(0)
 
 	slf.OnTick(func(c time.Time) {
-		stats := gopl.GetFioCounter()
+		stats := slf.Pipeline().FioCounter()
 		ic := stats.Inbounds()
 		fc := stats.Filtered()
 		oc := stats.Outbounds()
+		ec := stats.Expired()
+		rc := stats.Rejected()
+		sc := stats.Spilled()
+		dc := stats.DeadLetters()
 
 		// 统计每个周期的消息处理量
 		json := jsonx.NewFatJSON()
@@ -45,8 +53,12 @@ func (slf *GoPLDeliverCountInput) Input(deliverer gopl.Deliverer, decoder gopl.D
 		json.FieldNotEscapeValue("inbound", ic-inboundCount)
 		json.FieldNotEscapeValue("filter", fc-filtersCount)
 		json.FieldNotEscapeValue("outbound", oc-outputsCount)
+		json.FieldNotEscapeValue("expired", ec-expiredCount)
+		json.FieldNotEscapeValue("rejected", rc-rejectedCount)
+		json.FieldNotEscapeValue("spilled", sc-spilledCount)
+		json.FieldNotEscapeValue("dead_letter", dc-deadLetterCount)
 
-		avg := gopl.TakeDataFramesAvgSamples()
+		avg := slf.Pipeline().Samples().Avg()
 		json.FieldNotEscapeValue("avg.inbound", avg.InboundsAvg)
 		json.FieldNotEscapeValue("avg.filter", avg.FilteredAvg)
 		json.FieldNotEscapeValue("avg.outbound", avg.OutboundsAvg)
@@ -54,6 +66,10 @@ func (slf *GoPLDeliverCountInput) Input(deliverer gopl.Deliverer, decoder gopl.D
 		inboundCount = ic
 		filtersCount = fc
 		outputsCount = oc
+		expiredCount = ec
+		rejectedCount = rc
+		spilledCount = sc
+		deadLetterCount = dc
 
 		bytes := json.Bytes()
 		if pack, err := decoder.Decode(bytes); nil != err {
@@ -0,0 +1,265 @@
+package common
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"github.com/json-iterator/go"
+	"github.com/parkingwang/go-conf"
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 消息去重：在TTL时间窗口内，丢弃Key重复的消息。
+// Key可以是指定的Header、JSON Body中指定路径的字段，或者Body的哈希值。
+//
+
+const (
+	defaultDedupeTTL             = time.Minute * 10
+	defaultDedupeMaxKeys         = 100000
+	defaultDedupePersistInterval = time.Second * 30
+)
+
+type dedupeEntry struct {
+	key    string
+	expire int64 // UnixNano
+}
+
+type GoPLDedupeFilter struct {
+	gopl.AbcSlot
+
+	keyHeader   string
+	keyJSONPath []interface{} // JSON字段路径，数字表示数组下标
+	ttl         time.Duration
+	maxKeys     int
+	persistFile string
+
+	mu      *sync.Mutex
+	keys    map[string]*list.Element
+	entries *list.List // 按记录时间排序。TTL相同，即按过期时间排序
+
+	duplicates uint64
+
+	stop     chan struct{}
+	wg       *sync.WaitGroup
+	shutdown *sync.Once
+}
+
+func (slf *GoPLDedupeFilter) Init(args conf.Map) {
+	slf.AbcSlot.Init(args)
+	slf.keyHeader = args.MustString("key_header")
+	if path := args.MustString("key_json_path"); "" != path {
+		slf.keyJSONPath = parseJSONPath(path)
+	}
+	slf.ttl = args.GetDurationOrDefault("ttl", defaultDedupeTTL)
+	slf.maxKeys = int(args.GetInt64OrDefault("max_keys", defaultDedupeMaxKeys))
+	slf.persistFile = args.MustString("persist_file")
+	slf.mu = new(sync.Mutex)
+	slf.keys = make(map[string]*list.Element)
+	slf.entries = list.New()
+	slf.stop = make(chan struct{})
+	slf.wg = new(sync.WaitGroup)
+	slf.shutdown = new(sync.Once)
+
+	if "" != slf.persistFile {
+		slf.load()
+		slf.wg.Add(1)
+		go slf.persistLoop(args.GetDurationOrDefault("persist_interval", defaultDedupePersistInterval))
+	}
+	slf.TagLog(log.Info).Msgf("Dedupe by header: <%s>, json path: <%s>, ttl: %s, max keys: %d",
+		slf.keyHeader, args.MustString("key_json_path"), slf.ttl, slf.maxKeys)
+}
+
+func (slf *GoPLDedupeFilter) Filter(pack *gopl.DataFrame) *gopl.DataFrame {
+	key, ok := slf.keyOf(pack)
+	if !ok {
+		return nil
+	}
+	ele, dup := slf.seen(key, time.Now())
+	if dup {
+		atomic.AddUint64(&slf.duplicates, 1)
+		slf.TagLog(log.Debug).Msgf("Duplicated frame DROPPED, key: %s, sender: %s", key, pack.Sender())
+		return gopl.DropDataFrame
+	}
+	// 消息处理失败时移除Key，重新投递的消息不被视为重复
+	pack.OnComplete(func(err error) {
+		if nil != err {
+			slf.forget(key, ele)
+		}
+	})
+	return nil
+}
+
+// Duplicates 返回被丢弃的重复消息数量
+func (slf *GoPLDedupeFilter) Duplicates() uint64 {
+	return atomic.LoadUint64(&slf.duplicates)
+}
+
+// 停止时保存已记录的Key。未初始化或者重复调用时不处理。
+func (slf *GoPLDedupeFilter) Shutdown() {
+	if nil == slf.shutdown {
+		return
+	}
+	slf.shutdown.Do(func() {
+		close(slf.stop)
+		slf.wg.Wait()
+		if "" != slf.persistFile {
+			slf.save()
+		}
+	})
+}
+
+// 读取消息的去重Key。优先使用Header，其次为JSON字段，未配置时使用Body的SHA1值。
+// 消息没有对应的Key时，返回false，消息不参与去重。
+func (slf *GoPLDedupeFilter) keyOf(pack *gopl.DataFrame) (string, bool) {
+	if "" != slf.keyHeader {
+		key, ok := pack.Header(slf.keyHeader)
+		return key, ok && "" != key
+	}
+	body, err := pack.ReadBytes()
+	if nil != err {
+		slf.TagLog(log.Error).Err(err).Msgf("Read frame body FAILED, sender: %s", pack.Sender())
+		return "", false
+	}
+	if 0 < len(slf.keyJSONPath) {
+		field := jsoniter.Get(body, slf.keyJSONPath...)
+		if nil != field.LastError() || jsoniter.InvalidValue == field.ValueType() {
+			return "", false
+		}
+		return field.ToString(), true
+	}
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:]), true
+}
+
+// 检查Key是否已在时间窗口内出现。未出现时记录此Key，并返回记录的元素。
+func (slf *GoPLDedupeFilter) seen(key string, now time.Time) (*list.Element, bool) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.evict(now.UnixNano())
+	if _, hit := slf.keys[key]; hit {
+		return nil, true
+	}
+	return slf.remember(key, now.Add(slf.ttl).UnixNano()), false
+}
+
+// 移除处理失败的消息记录的Key。Key已被移除或者重新记录时不处理。
+func (slf *GoPLDedupeFilter) forget(key string, ele *list.Element) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if current, hit := slf.keys[key]; hit && current == ele {
+		slf.remove(ele)
+	}
+}
+
+func (slf *GoPLDedupeFilter) remember(key string, expire int64) *list.Element {
+	ele := slf.entries.PushBack(&dedupeEntry{key: key, expire: expire})
+	slf.keys[key] = ele
+	// 超过最大数量时，移除最早记录的Key
+	for slf.entries.Len() > slf.maxKeys {
+		slf.remove(slf.entries.Front())
+	}
+	return ele
+}
+
+// 移除已过期的Key
+func (slf *GoPLDedupeFilter) evict(now int64) {
+	for ele := slf.entries.Front(); nil != ele && ele.Value.(*dedupeEntry).expire <= now; ele = slf.entries.Front() {
+		slf.remove(ele)
+	}
+}
+
+func (slf *GoPLDedupeFilter) remove(ele *list.Element) {
+	slf.entries.Remove(ele)
+	delete(slf.keys, ele.Value.(*dedupeEntry).key)
+}
+
+func (slf *GoPLDedupeFilter) persistLoop(interval time.Duration) {
+	defer slf.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-slf.stop:
+			return
+		case <-ticker.C:
+			slf.save()
+		}
+	}
+}
+
+// 从文件恢复未过期的Key
+func (slf *GoPLDedupeFilter) load() {
+	data, err := ioutil.ReadFile(slf.persistFile)
+	if nil != err {
+		if !os.IsNotExist(err) {
+			slf.TagLog(log.Error).Err(err).Msgf("Load dedupe keys FAILED, file: %s", slf.persistFile)
+		}
+		return
+	}
+	entries := make([]dedupeRecord, 0)
+	if err := gopl.UnmarshalJSON(data, &entries); nil != err {
+		slf.TagLog(log.Error).Err(err).Msgf("Decode dedupe keys FAILED, file: %s", slf.persistFile)
+		return
+	}
+	now := time.Now().UnixNano()
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	for _, e := range entries {
+		if e.Expire > now {
+			if _, hit := slf.keys[e.Key]; !hit {
+				slf.remember(e.Key, e.Expire)
+			}
+		}
+	}
+	slf.TagLog(log.Info).Msgf("Load dedupe keys: %d, file: %s", slf.entries.Len(), slf.persistFile)
+}
+
+type dedupeRecord struct {
+	Key    string `json:"key"`
+	Expire int64  `json:"expire"`
+}
+
+// 将未过期的Key按记录顺序写入文件
+func (slf *GoPLDedupeFilter) save() {
+	slf.mu.Lock()
+	slf.evict(time.Now().UnixNano())
+	entries := make([]dedupeRecord, 0, slf.entries.Len())
+	for ele := slf.entries.Front(); nil != ele; ele = ele.Next() {
+		e := ele.Value.(*dedupeEntry)
+		entries = append(entries, dedupeRecord{Key: e.key, Expire: e.expire})
+	}
+	slf.mu.Unlock()
+	data, err := gopl.MarshalJSON(entries)
+	if nil == err {
+		tmp := slf.persistFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); nil == err {
+			err = os.Rename(tmp, slf.persistFile)
+		}
+	}
+	if nil != err {
+		slf.TagLog(log.Error).Err(err).Msgf("Save dedupe keys FAILED, file: %s", slf.persistFile)
+	}
+}
+
+// 解析以 . 分隔的JSON字段路径，例如 hook.events.0
+func parseJSONPath(path string) []interface{} {
+	out := make([]interface{}, 0)
+	for _, field := range strings.Split(path, ".") {
+		if index, err := strconv.Atoi(field); nil == err {
+			out = append(out, index)
+		} else {
+			out = append(out, field)
+		}
+	}
+	return out
+}
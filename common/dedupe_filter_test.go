@@ -0,0 +1,153 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/parkingwang/go-conf"
+	"github.com/yoojia/go-pipeline"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func newTestDedupeFilter(args conf.Map) *GoPLDedupeFilter {
+	filter := new(GoPLDedupeFilter)
+	filter.Init(args)
+	filter.SetName("GoPLDedupeFilter")
+	return filter
+}
+
+func newTestDedupeFrame(header, body string) *gopl.DataFrame {
+	pack := gopl.NewDataFrame()
+	if "" != header {
+		pack.SetHeader("X-Request-Id", header)
+	}
+	pack.SetBody(bytes.NewBufferString(body))
+	return pack
+}
+
+func TestDedupeFilter_TTL(t *testing.T) {
+	filter := newTestDedupeFilter(conf.Map{"key_header": "X-Request-Id"})
+	defer filter.Shutdown()
+	if nil != filter.Filter(newTestDedupeFrame("A", "")) {
+		t.Fatal("First frame should pass")
+	}
+	if gopl.DropDataFrame != filter.Filter(newTestDedupeFrame("A", "")) {
+		t.Fatal("Duplicated frame should be dropped")
+	}
+	if nil != filter.Filter(newTestDedupeFrame("", "")) {
+		t.Fatal("Frame without key should pass")
+	}
+	if 1 != filter.Duplicates() {
+		t.Fatalf("Duplicates not match, was: %d", filter.Duplicates())
+	}
+
+	// 时间窗口过后，Key被移除
+	now := time.Now()
+	if _, dup := filter.seen("B", now); dup {
+		t.Fatal("Key B should not be seen")
+	}
+	if _, dup := filter.seen("B", now.Add(filter.ttl-time.Second)); !dup {
+		t.Fatal("Key B should be seen within ttl")
+	}
+	if _, dup := filter.seen("B", now.Add(filter.ttl)); dup {
+		t.Fatal("Key B should expire after ttl")
+	}
+}
+
+func TestDedupeFilter_MaxKeys(t *testing.T) {
+	filter := newTestDedupeFilter(conf.Map{"key_header": "X-Request-Id", "max_keys": int64(2)})
+	defer filter.Shutdown()
+	now := time.Now()
+	for _, key := range []string{"A", "B", "C"} {
+		filter.seen(key, now)
+	}
+	if 2 != filter.entries.Len() {
+		t.Fatalf("Keys not match, was: %d", filter.entries.Len())
+	}
+	if _, hit := filter.keys["A"]; hit {
+		t.Fatal("Earliest key should be evicted")
+	}
+	if _, dup := filter.seen("C", now); !dup {
+		t.Fatal("Latest key should be kept")
+	}
+}
+
+func TestDedupeFilter_Forget(t *testing.T) {
+	filter := newTestDedupeFilter(conf.Map{"key_header": "X-Request-Id"})
+	defer filter.Shutdown()
+	now := time.Now()
+	ele, _ := filter.seen("A", now)
+	// 处理失败的消息移除Key，重新投递时不视为重复
+	filter.forget("A", ele)
+	if _, dup := filter.seen("A", now); dup {
+		t.Fatal("Forgotten key should not be seen")
+	}
+	// 已重新记录的Key不被旧记录移除
+	filter.forget("A", ele)
+	if _, dup := filter.seen("A", now); !dup {
+		t.Fatal("Key recorded again should be kept")
+	}
+}
+
+func TestDedupeFilter_JSONPath(t *testing.T) {
+	filter := newTestDedupeFilter(conf.Map{"key_json_path": "hook.events.1.id"})
+	defer filter.Shutdown()
+	body := `{"hook": {"events": [{"id": "e0"}, {"id": "%s"}]}}`
+	frame := func(id string) *gopl.DataFrame {
+		return newTestDedupeFrame("", fmt.Sprintf(body, id))
+	}
+	if nil != filter.Filter(frame("e1")) {
+		t.Fatal("First frame should pass")
+	}
+	if gopl.DropDataFrame != filter.Filter(frame("e1")) {
+		t.Fatal("Frame with same json key should be dropped")
+	}
+	if nil != filter.Filter(frame("e2")) {
+		t.Fatal("Frame with different json key should pass")
+	}
+	if nil != filter.Filter(newTestDedupeFrame("", `{"hook": {}}`)) || nil != filter.Filter(newTestDedupeFrame("", `{"hook": {}}`)) {
+		t.Fatal("Frame without json key should pass")
+	}
+}
+
+func TestDedupeFilter_Persist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-dedupe")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	args := conf.Map{"key_header": "X-Request-Id", "persist_file": filepath.Join(dir, "dedupe.json")}
+
+	filter := newTestDedupeFilter(args)
+	now := time.Now()
+	filter.seen("A", now)
+	filter.seen("B", now)
+	filter.seen("C", now.Add(-filter.ttl)) // 已过期，不保存
+	filter.Shutdown()
+	filter.Shutdown() // 重复调用不会panic
+
+	restored := newTestDedupeFilter(args)
+	defer restored.Shutdown()
+	if 2 != restored.entries.Len() {
+		t.Fatalf("Restored keys not match, was: %d", restored.entries.Len())
+	}
+	for _, key := range []string{"A", "B"} {
+		if _, dup := restored.seen(key, now); !dup {
+			t.Fatalf("Key %s should be restored", key)
+		}
+	}
+	if _, dup := restored.seen("C", now); dup {
+		t.Fatal("Expired key should not be restored")
+	}
+}
+
+func TestDedupeFilter_ShutdownWithoutInit(t *testing.T) {
+	new(GoPLDedupeFilter).Shutdown()
+}
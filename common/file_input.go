@@ -41,12 +41,13 @@ func (slf *GoPLFilePollingInput) Input(deliverer gopl.Deliverer, decoder gopl.De
 	ticker := time.NewTicker(slf.interval)
 	defer ticker.Stop()
 
-	vv := gopl.Debugs().VeryVerbose
+	vv := slf.Pipeline().Debugs().VeryVerbose
 
 	received := func(bytes []byte) {
 		pack, err := decoder.Decode(bytes)
 		if nil != err {
 			slf.TagLog(log.Error).Err(err).Msgf("Error when decode content from file: %s", slf.filePath)
+			gopl.DeliverDeadLetter(deliverer, bytes, err)
 		} else {
 			deliverer.Deliver(pack)
 		}
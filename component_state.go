@@ -0,0 +1,53 @@
+package gopl
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 组件运行状态，由管理接口控制
+//
+
+const (
+	ComponentRunning  = "running"  // 正常处理消息
+	ComponentPaused   = "paused"   // 暂停处理消息，可以恢复
+	ComponentDisabled = "disabled" // 组件已停止，重新加载配置后恢复
+)
+
+type componentState struct {
+	paused   *AtomicBoolean
+	disabled *AtomicBoolean
+}
+
+func newComponentState() *componentState {
+	return &componentState{
+		paused:   NewAtomicBoolean(),
+		disabled: NewAtomicBoolean(),
+	}
+}
+
+// 返回组件是否暂停处理消息。已停止的组件也不处理消息。
+func (slf *componentState) isPaused() bool {
+	return slf.paused.Get()
+}
+
+func (slf *componentState) setPaused(paused bool) {
+	slf.paused.Set(paused)
+}
+
+func (slf *componentState) isDisabled() bool {
+	return slf.disabled.Get()
+}
+
+func (slf *componentState) disable() {
+	slf.paused.Set(true)
+	slf.disabled.Set(true)
+}
+
+// 返回组件的运行状态名称
+func (slf *componentState) status() string {
+	if slf.disabled.Get() {
+		return ComponentDisabled
+	} else if slf.paused.Get() {
+		return ComponentPaused
+	} else {
+		return ComponentRunning
+	}
+}
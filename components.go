@@ -31,6 +31,13 @@ type (
 		GetDeliverer() Deliverer
 	}
 
+	// 组件根据实现，是否需要获取所属的Pipeline实例。
+	// 如果启用，会在初始化前设置Pipeline实例。组件通过它来读取配置和统计数据，而不是使用默认实例。
+	NeedPipeline interface {
+		SetPipeline(pipeline *GoPipeline)
+		Pipeline() *GoPipeline
+	}
+
 	// 组件根据实现，是否支持Shutdown接口。
 	// 如果启用，在程序关闭时会调用Shutdown接口。
 	NeedShutdown interface {
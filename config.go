@@ -18,6 +18,24 @@ type ComponentConfig struct {
 	Topic         string   `toml:"topic"`     // Topic名称。Input/Filter/Output插件使用此字段来匹配消息
 	DecoderName   string   `toml:"decoder"`   // Decoder名称，Output插件使用此字段
 	InitArgs      conf.Map `toml:"InitArgs"`  // 插件初始化参数
+
+	DeliverTimeout string `toml:"deliver_timeout"` // Input消息处理超时时间，覆盖全局配置
+	Priority       string `toml:"priority"`        // Input消息的优先级：high/normal/low。消息未设置Priority Header时注入
+
+	QueueSize int    `toml:"queue_size"` // Output独立消息队列容量。大于0时启用队列，Router只将消息放入队列
+	Workers   int    `toml:"workers"`    // Output独立消息队列的处理协程数量，默认为1
+	Overflow  string `toml:"overflow"`   // Output独立消息队列已满时的处理策略：block/drop_new/drop_old，默认为block
+
+	BatchSize  int    `toml:"batch_size"`  // 实现BatchOutput的Output，每个批次的最多消息数量，默认100
+	BatchBytes int64  `toml:"batch_bytes"` // 每个批次的最多消息字节数，0表示不限制
+	Linger     string `toml:"linger"`      // 批次未满时，等待更多消息的最长时间，默认100ms
+
+	Retry          *RetryConfig          `toml:"retry"`           // Output处理失败时的重试配置，未配置时不重试
+	CircuitBreaker *CircuitBreakerConfig `toml:"circuit_breaker"` // Output熔断器配置，未配置时不熔断
+
+	RateLimit *RateLimitConfig `toml:"rate_limit"` // Input投递或者Output处理消息的限流配置，未配置时不限流
+
+	Spool *SpoolConfig `toml:"spool"` // 下游不可用时，Output消息的磁盘暂存配置，未配置时不暂存
 }
 
 // 调试配置选项
@@ -29,21 +47,71 @@ type DebugConfig struct {
 	BlockDetectTime string `toml:"block_detect_time"`  // 消息处理阻塞检测时间
 }
 
-// 获取全局Globals配置。
+// Filter处理消息的模式
+const (
+	FilterModeFanout = "fanout" // 扇出：每个Filter都处理原始消息。默认模式
+	FilterModeChain  = "chain"  // 链式：Filter返回的新消息作为下一个Filter的输入
+)
+
+// 路由配置选项。
+// 它对应着配置文件 [Globals] 配置项中由框架使用的字段。
+type RouterConfig struct {
+	DeliverTimeout string   `toml:"deliver_timeout"` // 消息处理超时时间，超时的消息将被放弃处理。默认不限制
+	FilterMode     string   `toml:"filter_mode"`     // Filter处理消息的模式：fanout/chain，默认为fanout
+	FilterChain    []string `toml:"filter_chain"`    // Filter处理消息的顺序，使用Filter的配置名声明
+	Backpressure   string   `toml:"backpressure"`    // Router过载时的背压策略：block/reject/drop_oldest/spill，默认为block
+	MaxPending     int      `toml:"max_pending"`     // 入口队列容量，非block策略有效。默认1024
+	SpillDir       string   `toml:"spill_dir"`       // spill策略的磁盘队列目录
+	SpillMaxBytes  int64    `toml:"spill_max_bytes"` // spill策略的磁盘队列最大字节数，超过时拒绝消息。0表示不限制
+	DrainTimeout   string   `toml:"drain_timeout"`   // 停止时等待处理中消息完成的最长时间，默认5s
+
+	DeadLetterTopic string `toml:"dead_letter_topic"` // 死信Topic。处理失败的消息被重新投递到此Topic，为空时不投递
+
+	OrderingKey   string `toml:"ordering_key"`   // 按此Header的值顺序处理消息。相同值的消息按到达顺序经过Filter和Output。为空时不保证顺序
+	OrderingLanes int    `toml:"ordering_lanes"` // 顺序处理的通道数量，默认为 CPU数量 * 2
+
+	ParallelOutputs bool `toml:"parallel_outputs"` // 是否并行处理消息的多个Output，默认按顺序处理
+
+	PriorityWeights   []int `toml:"priority_weights"`    // high/normal/low优先级队列的调度权重，例如 [8, 4, 1]。为空时不区分优先级
+	PriorityQueueSize int   `toml:"priority_queue_size"` // 每个优先级队列的容量，默认1024
+
+	WalDir          string `toml:"wal_dir"`           // 预写日志目录。Input投递的消息先写入磁盘再处理，为空时不启用
+	WalMaxBytes     int64  `toml:"wal_max_bytes"`     // 预写日志最大字节数，超过时拒绝消息。0表示不限制
+	WalSegmentBytes int64  `toml:"wal_segment_bytes"` // 预写日志单个分段文件的最大字节数，默认64MB
+	WalRetention    string `toml:"wal_retention"`     // 预写日志中消息的最长保留时间，超过时放弃处理。默认不限制
+}
+
+// 获取默认Pipeline实例的Globals配置。
 // 它对应着配置文件的 [Globals] 配置项。
 func Globals() conf.Map {
-	return SharedRouter().globalsConfig
+	return SharedRouter().Globals()
 }
 
-// 获取全局 Debug 配置。
+// 获取默认Pipeline实例的 Debug 配置。
 // 它对应着配置文件的 [Debug] 配置项。
 func Debugs() DebugConfig {
-	return SharedRouter().debugConfig
+	return SharedRouter().Debugs()
 }
 
-// FindConfigOnRoot 返回从根配置中查找指定命名的配置对象。
+// FindConfigOnRoot 返回从默认Pipeline实例的根配置中查找指定命名的配置对象。
 // 如果配置不存在，返回nil, false，否则为 config, true
 func FindConfigOnRoot(name string) (conf.Map, bool) {
-	cnf := SharedRouter().rootConfig.GetMapOrDefault(name, nil)
+	return SharedRouter().FindConfigOnRoot(name)
+}
+
+// Globals 返回Pipeline实例的Globals配置
+func (slf *GoPipeline) Globals() conf.Map {
+	return slf.globalsConfig
+}
+
+// Debugs 返回Pipeline实例的 Debug 配置
+func (slf *GoPipeline) Debugs() DebugConfig {
+	return slf.debugConfig
+}
+
+// FindConfigOnRoot 返回从Pipeline实例的根配置中查找指定命名的配置对象。
+// 如果配置不存在，返回nil, false，否则为 config, true
+func (slf *GoPipeline) FindConfigOnRoot(name string) (conf.Map, bool) {
+	cnf := slf.rootConfig.GetMapOrDefault(name, nil)
 	return cnf, nil != cnf
 }
@@ -1,8 +1,10 @@
 package gopl
 
 import (
+	"context"
 	"github.com/parkingwang/go-conf"
 	"io/ioutil"
+	"time"
 )
 
 //// 签名 ////
@@ -26,6 +28,14 @@ type DataFrame struct {
 	traces  []*Trace // 处理流程跟踪列表。此字段按顺序记录所有处理过此消息的插件签名。
 	topic   string   // 此消息所属的Topic
 	headers Headers  // 消息头部，用以设置额外的参数
+	refs    int32    // 引用计数。消息被异步处理时，需要等待所有引用释放后才能回收
+
+	replacing bool // Filter返回的新消息是否替换原消息
+
+	deadline time.Time          // 消息处理的截止时间。零值表示不限制
+	ctx      context.Context    // 携带截止时间的Context
+	cancel   context.CancelFunc // 释放Context的定时器
+	ack      *ackGroup          // 消息处理确认组，未设置确认回调时为nil
 	*MultiReader
 }
 
@@ -111,6 +121,43 @@ func (slf *DataFrame) Sender() string {
 	}
 }
 
+// SetDeadline 设置消息处理的截止时间。超过截止时间的消息，将被Router放弃处理。
+func (slf *DataFrame) SetDeadline(deadline time.Time) {
+	if nil != slf.cancel {
+		slf.cancel()
+	}
+	slf.deadline = deadline
+	slf.ctx, slf.cancel = context.WithDeadline(context.Background(), deadline)
+}
+
+// 清除消息处理的截止时间
+func (slf *DataFrame) clearDeadline() {
+	if nil != slf.cancel {
+		slf.cancel()
+	}
+	slf.deadline = time.Time{}
+	slf.ctx, slf.cancel = nil, nil
+}
+
+// Deadline 返回消息处理的截止时间。如果未设置，返回 false
+func (slf *DataFrame) Deadline() (time.Time, bool) {
+	return slf.deadline, !slf.deadline.IsZero()
+}
+
+// Context 返回携带消息截止时间的Context，Filter和Output可以通过它来响应消息超时。
+// 未设置截止时间时，返回 context.Background()
+func (slf *DataFrame) Context() context.Context {
+	if nil == slf.ctx {
+		return context.Background()
+	}
+	return slf.ctx
+}
+
+// 判断消息是否已超过截止时间
+func (slf *DataFrame) isExpired(now time.Time) bool {
+	return !slf.deadline.IsZero() && now.After(slf.deadline)
+}
+
 func (slf *DataFrame) addTrace(pluginName string, timestamp int64) {
 	trace := &Trace{
 		Name:      pluginName,
@@ -121,6 +168,26 @@ func (slf *DataFrame) addTrace(pluginName string, timestamp int64) {
 	}
 }
 
+// 创建消息的副本。副本复制Header、Topic、跟踪信息和截止时间，与原消息共享Body数据。
+func (slf *DataFrame) derive() *DataFrame {
+	out := ObtainDataFrame()
+	for k, v := range slf.headers {
+		out.headers[k] = v
+	}
+	for _, t := range slf.Traces() {
+		out.addTrace(t.Name, t.Timestamp)
+	}
+	out.topic = slf.topic
+	if deadline, set := slf.Deadline(); set {
+		out.SetDeadline(deadline)
+	}
+	out.bodyRaw = nil
+	out.bodyLength = slf.bodyLength
+	out.bodyGetFunc = slf.bodyGetFunc
+	out.inheritAck(slf)
+	return out
+}
+
 func (slf *DataFrame) setTopic(topic string) {
 	slf.topic = topic
 }
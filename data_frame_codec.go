@@ -0,0 +1,59 @@
+package gopl
+
+import (
+	"bytes"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 消息对象的序列化，用于将消息写入磁盘
+//
+
+type frameRecord struct {
+	Topic    string   `json:"topic"`
+	Headers  Headers  `json:"headers"`
+	Traces   []*Trace `json:"traces"`
+	Deadline int64    `json:"deadline"` // UnixNano，0表示不限制
+	Body     []byte   `json:"body"`
+}
+
+// 将消息序列化成字节数组
+func encodeDataFrame(pack *DataFrame) ([]byte, error) {
+	body, err := pack.ReadBytes()
+	if nil != err {
+		return nil, err
+	}
+	record := frameRecord{
+		Topic:   pack.Topic(),
+		Headers: pack.headers,
+		Traces:  pack.Traces(),
+		Body:    body,
+	}
+	if deadline, ok := pack.Deadline(); ok {
+		record.Deadline = deadline.UnixNano()
+	}
+	return MarshalJSON(record)
+}
+
+// 从字节数组恢复消息对象
+func decodeDataFrame(data []byte) (*DataFrame, error) {
+	record := frameRecord{}
+	if err := UnmarshalJSON(data, &record); nil != err {
+		return nil, err
+	}
+	pack := ObtainDataFrame()
+	pack.setTopic(record.Topic)
+	pack.SetHeaders(record.Headers)
+	for _, t := range record.Traces {
+		pack.addTrace(t.Name, t.Timestamp)
+	}
+	if 0 < record.Deadline {
+		pack.SetDeadline(time.Unix(0, record.Deadline))
+	}
+	if err := pack.SetBody(bytes.NewBuffer(record.Body)); nil != err {
+		releaseDataFrame(pack)
+		return nil, err
+	}
+	return pack, nil
+}
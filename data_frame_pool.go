@@ -1,6 +1,10 @@
 package gopl
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 //
 // Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
@@ -26,11 +30,21 @@ func NewDataFrame() *DataFrame {
 	out.headers = make(Headers)
 	out.traces = make([]*Trace, 6)
 	out.topic = ""
+	out.refs = 1
 	return out
 }
 
+// 增加消息对象的引用计数。消息交给其它协程异步处理时，需要先增加引用。
+func retainDataFrame(df *DataFrame) {
+	atomic.AddInt32(&df.refs, 1)
+}
+
 // 将消息对象释放，重置对象数据，并放回对象池中。
+// 如果消息对象仍被其它协程引用，只减少引用计数。
 func releaseDataFrame(df *DataFrame) {
+	if 0 < atomic.AddInt32(&df.refs, -1) {
+		return
+	}
 	df.Close()
 	for k := range df.headers {
 		delete(df.headers, k)
@@ -39,5 +53,19 @@ func releaseDataFrame(df *DataFrame) {
 		df.traces[i] = nil
 	}
 	df.topic = ""
+	df.refs = 1
+	df.replacing = false
+	if nil != df.cancel {
+		df.cancel()
+	}
+	df.deadline = time.Time{}
+	df.ctx = nil
+	df.cancel = nil
+	ack := df.ack
+	df.ack = nil
 	gDataFramePool.Put(df)
+	// 消息的所有引用释放后，确认组减少一个待处理消息
+	if nil != ack {
+		ack.done()
+	}
 }
@@ -5,6 +5,7 @@ import (
 	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestDataFrameBody(t *testing.T) {
@@ -27,3 +28,32 @@ func TestDataFrameBody(t *testing.T) {
 		t.Fatal("Not match, was: " + txt())
 	}
 }
+
+func TestDataFrameDeadline(t *testing.T) {
+	df := NewDataFrame()
+	if _, set := df.Deadline(); set {
+		t.Fatal("Deadline should not set")
+	}
+	if nil != df.Context().Done() {
+		t.Fatal("Context should never be done")
+	}
+
+	now := time.Now()
+	df.SetDeadline(now.Add(time.Millisecond * 10))
+	if df.isExpired(now) {
+		t.Fatal("Should not expired")
+	}
+	select {
+	case <-df.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Context should be done after deadline")
+	}
+	if !df.isExpired(time.Now()) {
+		t.Fatal("Should be expired")
+	}
+
+	releaseDataFrame(df)
+	if _, set := df.Deadline(); set {
+		t.Fatal("Deadline should be reset after release")
+	}
+}
@@ -0,0 +1,122 @@
+package gopl
+
+import (
+	"bytes"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 死信消息：解码、Filter和Output处理失败的消息，重新投递到死信Topic
+//
+
+// 死信消息的Header字段
+const (
+	HeaderDeadLetterStage     = "DeadLetter-Stage"     // 处理失败的阶段：decode/filter/output
+	HeaderDeadLetterComponent = "DeadLetter-Component" // 处理失败的组件配置名
+	HeaderDeadLetterError     = "DeadLetter-Error"     // 错误信息
+	HeaderDeadLetterTopic     = "DeadLetter-Topic"     // 原消息的Topic
+)
+
+// 死信消息处理失败的阶段
+const (
+	DeadLetterStageDecode = "decode"
+	DeadLetterStageFilter = "filter"
+	DeadLetterStageOutput = "output"
+)
+
+// 未配置 dead_letter_topic 时，投递死信消息返回的错误
+var ErrDeadLetterDisabled = errors.New("dead letter is disabled")
+
+// 可投递死信消息的消息投递接口。Router为Input提供的Deliverer实现了此接口。
+type DeadLetterDeliverer interface {
+	Deliverer
+	// 将解码失败的原始数据，作为死信消息投递到死信Topic
+	DeliverDeadLetter(raw []byte, cause error) error
+}
+
+// DeliverDeadLetter 将Input解码失败的原始数据投递到死信Topic。
+// Deliverer未实现 DeadLetterDeliverer 接口，或者未配置死信Topic时，返回 ErrDeadLetterDisabled。
+func DeliverDeadLetter(deliverer Deliverer, raw []byte, cause error) error {
+	if dd, ok := deliverer.(DeadLetterDeliverer); ok {
+		return dd.DeliverDeadLetter(raw, cause)
+	}
+	return ErrDeadLetterDisabled
+}
+
+// 创建死信消息。消息Body为原始数据，Header保留原消息的Header，并记录失败的阶段、组件、错误和原Topic。
+// 原消息已是死信消息时，返回nil，避免死信消息循环投递。
+func (slf *GoPipeline) newDeadLetter(stage, component string, origin *DataFrame, raw []byte, cause error) *DataFrame {
+	// 死信Topic随路由快照在Reload时替换，不直接读取 routerConfig
+	snap, ok := slf.snapshot.Load().(*routeSnapshot)
+	if !ok || "" == snap.deadLetter {
+		return nil
+	}
+	topic := snap.deadLetter
+	if nil != origin {
+		if isDeadLetter(origin) || topic == origin.Topic() {
+			withTag(log.Warn).Msgf("DeadLetter: <%s> failed to handle dead letter, dropped, sender: %s", component, origin.Sender())
+			return nil
+		}
+	}
+	frame := ObtainDataFrame()
+	if nil != origin {
+		for k, v := range origin.headers {
+			frame.SetHeader(k, v)
+		}
+		for _, t := range origin.Traces() {
+			frame.addTrace(t.Name, t.Timestamp)
+		}
+		frame.SetHeader(HeaderDeadLetterTopic, origin.Topic())
+		if nil == raw {
+			raw, _ = origin.ReadBytes()
+		}
+	}
+	frame.addTrace(component, time.Now().UnixNano())
+	frame.SetHeader(HeaderDeadLetterStage, stage)
+	frame.SetHeader(HeaderDeadLetterComponent, component)
+	frame.SetHeader(HeaderDeadLetterError, cause.Error())
+	frame.setTopic(topic)
+	frame.SetBody(bytes.NewReader(raw))
+	return frame
+}
+
+// 判断消息是否为死信消息。死信消息总是按Topic路由，不经过命名管道。
+func isDeadLetter(pack *DataFrame) bool {
+	_, is := pack.Header(HeaderDeadLetterStage)
+	return is
+}
+
+// 投递Filter或者Output处理失败的消息。在协程池内调用，异步投递以避免等待协程池。
+func (slf *GoPipeline) deadLetter(stage, component string, origin *DataFrame, cause error) {
+	frame := slf.newDeadLetter(stage, component, origin, nil, cause)
+	if nil == frame {
+		return
+	}
+	slf.fio.increaseDeadLetter()
+	slf.inflight.Add(1)
+	go func() {
+		defer slf.inflight.Add(-1)
+		if err := slf.TryDeliver(frame); nil != err {
+			withTag(log.Error).Err(err).Msgf("DeadLetter: deliver FAILED, component: %s", component)
+		}
+	}()
+}
+
+// 投递Input解码失败的原始数据
+func (slf *delivererProxy) DeliverDeadLetter(raw []byte, cause error) error {
+	frame := slf.pipeline.newDeadLetter(DeadLetterStageDecode, slf.signer, nil, raw, cause)
+	if nil == frame {
+		return ErrDeadLetterDisabled
+	}
+	frame.SetHeader("Origin", slf.signer)
+	frame.SetHeaders(slf.injectHeaders)
+	frame.SetHeader(HeaderDeadLetterTopic, slf.injectTopic)
+	if err := slf.pipeline.TryDeliver(frame); nil != err {
+		return err
+	}
+	slf.pipeline.fio.increaseDeadLetter()
+	return nil
+}
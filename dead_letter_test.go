@@ -0,0 +1,171 @@
+package gopl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 总是处理失败的Output
+type testFailOutput struct {
+	AbcSlot
+}
+
+func (slf *testFailOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testFailOutput) OutputContext(ctx context.Context, pack *DataFrame) error {
+	return errors.New("broker unavailable")
+}
+
+// 记录收到的死信消息
+type testDeadLetterOutput struct {
+	AbcSlot
+	mu     sync.Mutex
+	frames []testDeadLetter
+}
+
+type testDeadLetter struct {
+	headers Headers
+	body    string
+}
+
+func (slf *testDeadLetterOutput) Output(pack *DataFrame) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	headers := make(Headers)
+	for k, v := range pack.headers {
+		headers[k] = v
+	}
+	body, _ := pack.ReadBytes()
+	slf.frames = append(slf.frames, testDeadLetter{headers: headers, body: string(body)})
+}
+
+func TestRouter_DeadLetter(t *testing.T) {
+	router := newRouter(1)
+	router.routerConfig.DeadLetterTopic = "/dlq"
+	failed := new(testFailOutput)
+	failed.SetName("FailOutput")
+	router.outputRunners.PushBack(newOutputRunner(failed, new(AnyMatcher), &ComponentConfig{}, "FailOutput"))
+	matcher, err := NewDefaultURLMatcher("/dlq")
+	if nil != err {
+		t.Fatal(err)
+	}
+	dlq := new(testDeadLetterOutput)
+	dlq.SetName("DeadLetterOutput")
+	router.outputRunners.PushBack(newOutputRunner(dlq, matcher, &ComponentConfig{}, "DeadLetterOutput"))
+	router.buildRouteTable()
+	router.drainTimeout = time.Second
+	router.threads.Start()
+	defer router.threads.Shutdown()
+
+	pack := NewDataFrame()
+	pack.setTopic("/data")
+	pack.SetHeader("device", "A01")
+	pack.SetBody(strings.NewReader("payload"))
+	router.post(pack)
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain all frames, dropped: %d", dropped)
+	}
+
+	// 死信消息在FailOutput再次失败，不再重复投递
+	if 1 != len(dlq.frames) {
+		t.Fatalf("Dead letters not match, was: %d", len(dlq.frames))
+	}
+	frame := dlq.frames[0]
+	if "payload" != frame.body || "A01" != frame.headers["device"] ||
+		DeadLetterStageOutput != frame.headers[HeaderDeadLetterStage] ||
+		"FailOutput" != frame.headers[HeaderDeadLetterComponent] ||
+		"/data" != frame.headers[HeaderDeadLetterTopic] ||
+		"broker unavailable" != frame.headers[HeaderDeadLetterError] {
+		t.Fatalf("Dead letter not match, was: %+v", frame)
+	}
+	if 1 != router.fio.DeadLetters() {
+		t.Fatalf("Dead letter counter not match, was: %d", router.fio.DeadLetters())
+	}
+}
+
+func TestRouter_DeadLetterInPipeline(t *testing.T) {
+	router := newRouter(1)
+	router.routerConfig.DeadLetterTopic = "/dlq"
+	failed := new(testFailOutput)
+	failed.SetName("FailOutput")
+	router.outputRunners.PushBack(newOutputRunner(failed, new(AnyMatcher), &ComponentConfig{}, "FailOutput"))
+	matcher, err := NewDefaultURLMatcher("/dlq")
+	if nil != err {
+		t.Fatal(err)
+	}
+	dlq := new(testDeadLetterOutput)
+	dlq.SetName("DeadLetterOutput")
+	router.outputRunners.PushBack(newOutputRunner(dlq, matcher, &ComponentConfig{}, "DeadLetterOutput"))
+	router.inputRunners.PushBack(newInputRunner(nil, nil, &ComponentConfig{}, "InputA", 0))
+	router.rootConfig[pipelineConfigKey] = []interface{}{
+		map[string]interface{}{
+			"name":    "a",
+			"inputs":  []interface{}{"InputA"},
+			"outputs": []interface{}{"FailOutput"},
+		},
+	}
+	router.setupPipelines()
+	router.buildRouteTable()
+	router.drainTimeout = time.Second
+	router.threads.Start()
+	defer router.threads.Shutdown()
+
+	pack := NewDataFrame()
+	pack.addTrace("InputA", 0)
+	pack.setTopic("/data")
+	pack.SetBody(strings.NewReader("payload"))
+	router.post(pack)
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain all frames, dropped: %d", dropped)
+	}
+
+	// 死信消息按Topic路由到DeadLetterOutput，不再回到管道a
+	if 1 != len(dlq.frames) {
+		t.Fatalf("Dead letters not match, was: %d", len(dlq.frames))
+	}
+	if "FailOutput" != dlq.frames[0].headers[HeaderDeadLetterComponent] || "payload" != dlq.frames[0].body {
+		t.Fatalf("Dead letter not match, was: %+v", dlq.frames[0])
+	}
+}
+
+func TestRouter_DeadLetterTopicSwap(t *testing.T) {
+	router := newRouter(1)
+	if nil != router.newDeadLetter(DeadLetterStageOutput, "FailOutput", nil, []byte("x"), errors.New("failed")) {
+		t.Fatal("Should not create dead letter before route table built")
+	}
+	router.routerConfig.DeadLetterTopic = "/dlq-a"
+	router.buildRouteTable()
+
+	// 读取死信Topic与重建路由快照并发执行
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if frame := router.newDeadLetter(DeadLetterStageOutput, "FailOutput", nil, []byte("x"), errors.New("failed")); nil != frame {
+				releaseDataFrame(frame)
+			}
+		}
+	}()
+	router.reloadMu.Lock()
+	nextConfig := router.routerConfig
+	nextConfig.DeadLetterTopic = "/dlq-b"
+	router.routerConfig = nextConfig
+	router.buildRouteTable()
+	router.reloadMu.Unlock()
+	<-done
+
+	frame := router.newDeadLetter(DeadLetterStageOutput, "FailOutput", nil, []byte("x"), errors.New("failed"))
+	if nil == frame || "/dlq-b" != frame.Topic() {
+		t.Fatalf("Dead letter topic should be swapped, was: %v", frame)
+	}
+	releaseDataFrame(frame)
+}
@@ -0,0 +1,98 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 停止时等待处理中的消息完成
+//
+
+const defaultDrainTimeout = time.Second * 5
+
+// 在Input停止后调用，按顺序等待：预写日志停止读取、入口队列派发完成、协程池中的消息处理完成、Output独立队列处理完成。
+// 超过 drain_timeout 时放弃等待，剩余的消息不再处理。返回未处理完成的消息数量。
+func (slf *GoPipeline) drain() int64 {
+	deadline := time.Now().Add(slf.drainTimeout)
+	waitUntil := func(done <-chan struct{}) bool {
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		defer timer.Stop()
+		select {
+		case <-done:
+			return true
+		case <-timer.C:
+			return false
+		}
+	}
+
+	// Write-ahead log: 停止读取日志，未读取的消息保留在日志中
+	if nil != slf.wal {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			slf.wal.stopConsume()
+		}()
+		waitUntil(done)
+	}
+
+	// Ingress: 停止接收消息，将入口队列中的消息派发到协程池
+	if nil != slf.ingress {
+		withTag(log.Info).Msgf("Drain ingress, pending: %d", len(slf.ingress.frames))
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			slf.ingress.close()
+		}()
+		waitUntil(done)
+	}
+
+	// Core Threads: 等待协程池中的消息经过所有Filter和Output
+	withTag(log.Info).Msgf("Drain in-flight frames: %d", slf.inflight.Get())
+	inflight := make(chan struct{})
+	go func() {
+		defer close(inflight)
+		for 0 < slf.inflight.Get() && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 10)
+		}
+	}()
+	waitUntil(inflight)
+
+	// Output Queues: 等待队列中的消息处理完成
+	queues := make([]*outputQueue, 0)
+	for ele := slf.outputRunners.Back(); ele != nil; ele = ele.Prev() {
+		if queue := ele.Value.(*outputRunner).queue; nil != queue {
+			queues = append(queues, queue)
+		}
+	}
+	if 0 < len(queues) {
+		wg := new(sync.WaitGroup)
+		for _, queue := range queues {
+			withTag(log.Info).Msgf("Drain output queue: %s, remains: %d", queue.name, queue.counter.Depth())
+			wg.Add(1)
+			go func(queue *outputQueue) {
+				defer wg.Done()
+				queue.close()
+			}(queue)
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			wg.Wait()
+		}()
+		waitUntil(done)
+	}
+
+	// 统计剩余的消息，并放弃处理
+	slf.stopped.Set(true)
+	dropped := slf.inflight.Get()
+	if nil != slf.ingress {
+		dropped += int64(len(slf.ingress.frames))
+	}
+	for _, queue := range queues {
+		dropped += int64(queue.counter.Depth())
+	}
+	return dropped
+}
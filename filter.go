@@ -1,6 +1,7 @@
 package gopl
 
 import (
+	"context"
 	"github.com/rs/zerolog/log"
 	"time"
 )
@@ -12,34 +13,67 @@ import (
 type Filter interface {
 	VirtualSlot
 
-	// 处理消息，并返回结果
+	// 处理消息，并返回结果：
+	// - 返回nil或原消息：原消息继续交给后续Filter和Output处理；
+	// - 返回新消息：扇出模式下，新消息与原消息一起交给Output处理；链式模式下，新消息作为下一个Filter的输入；
+	// - 返回 ReplaceDataFrame(新消息)：新消息替换原消息，原消息不再交给Output处理；
+	// - 返回 DropDataFrame：丢弃原消息，不再交给后续Filter和Output处理；
 	Filter(pack *DataFrame) *DataFrame
 }
 
+// DropDataFrame Filter返回此对象，表示丢弃当前处理的消息。
+var DropDataFrame = &DataFrame{}
+
+// ReplaceDataFrame 标记Filter返回的新消息替换原消息。
+func ReplaceDataFrame(pack *DataFrame) *DataFrame {
+	pack.replacing = true
+	return pack
+}
+
+// FilterContext Filter组件根据实现，是否支持Context和错误返回。
+// 如果实现，Router优先调用此接口处理消息；Filter接口仍需实现，以保持兼容。
+type FilterContext interface {
+	// 处理消息，并返回结果。Context携带消息的截止时间。
+	// 返回错误时，Router忽略返回的消息，并记录此Filter的错误计数。
+	FilterContext(ctx context.Context, pack *DataFrame) (*DataFrame, error)
+}
+
 type NewFilterFactory func() Filter
 
 type filterRunner struct {
 	filter    Filter
+	filterCtx FilterContext
 	matcher   Matcher
 	config    *ComponentConfig
 	configKey string
+	counter   *ComponentCounter
+
+	*componentState
 }
 
 func newFilterRunner(filter Filter, matcher Matcher, config *ComponentConfig, configKey string) *filterRunner {
+	filterCtx, _ := filter.(FilterContext)
 	return &filterRunner{
 		filter:    filter,
+		filterCtx: filterCtx,
 		matcher:   matcher,
 		config:    config,
 		configKey: configKey,
+		counter:   newComponentCounter(configKey),
+
+		componentState: newComponentState(),
 	}
 }
 
-func (slf *filterRunner) init(deliverer Deliverer) {
+func (slf *filterRunner) init(pipeline *GoPipeline) {
 	pluginName := slf.configKey
 	slf.filter.SetName(pluginName)
+	if need, ok := slf.filter.(NeedPipeline); ok {
+		need.SetPipeline(pipeline)
+	}
 	// check deliverer supports
 	if need, ok := slf.filter.(NeedDeliverer); ok {
-		need.SetDeliverer(deliverer)
+		need.SetDeliverer(pipeline)
 	}
 	slf.filter.Init(slf.config.InitArgs)
 	log.Info().Msgf("Init Filter: <%s>, matcher: <%T>", pluginName, slf.matcher)
@@ -49,11 +83,29 @@ func (slf *filterRunner) checkAccept(pack *DataFrame) bool {
 	return slf.matcher.Match(pack)
 }
 
-func (slf *filterRunner) runFilter(ts time.Time, pack *DataFrame) *DataFrame {
+func (slf *filterRunner) runFilter(ts time.Time, pack *DataFrame) (*DataFrame, error) {
 	name := slf.filter.GetName()
 	pack.addTrace(name, ts.UnixNano())
 	// 处理并返回结果
-	ret := slf.filter.Filter(pack)
+	var ret *DataFrame
+	if nil != slf.filterCtx {
+		if out, err := slf.filterCtx.FilterContext(pack.Context(), pack); nil != err {
+			slf.counter.increaseErrors()
+			if nil != out && pack != out && DropDataFrame != out {
+				releaseDataFrame(out)
+			}
+			return nil, err
+		} else {
+			ret = out
+		}
+	} else {
+		ret = slf.filter.Filter(pack)
+	}
+	slf.counter.increaseHandled()
+	if DropDataFrame == ret {
+		slf.counter.increaseDropped()
+		return ret, nil
+	}
 	// 返回新结果时，复制Msg的基础参数
 	if nil != ret && pack != ret {
 		ret.SetHeader("Origin", name)
@@ -64,6 +116,11 @@ func (slf *filterRunner) runFilter(ts time.Time, pack *DataFrame) *DataFrame {
 			}
 		}
 		ret.setTopic(pack.topic)
+		if deadline, set := pack.Deadline(); set {
+			if _, has := ret.Deadline(); !has {
+				ret.SetDeadline(deadline)
+			}
+		}
 	}
-	return ret
+	return ret, nil
 }
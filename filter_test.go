@@ -0,0 +1,58 @@
+package gopl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+type testContextFilter struct {
+	AbcSlot
+	err error
+}
+
+func (slf *testContextFilter) Filter(pack *DataFrame) *DataFrame {
+	panic("Should call FilterContext")
+}
+
+func (slf *testContextFilter) FilterContext(ctx context.Context, pack *DataFrame) (*DataFrame, error) {
+	if nil != slf.err {
+		return nil, slf.err
+	}
+	out := NewDataFrame()
+	out.SetHeader("filtered", "true")
+	return out, nil
+}
+
+func TestFilterRunner_FilterContext(t *testing.T) {
+	filter := new(testContextFilter)
+	filter.SetName("TestContextFilter")
+	runner := newFilterRunner(filter, new(AnyMatcher), &ComponentConfig{}, "TestContextFilter")
+
+	pack := NewDataFrame()
+	pack.setTopic("/test")
+	pack.SetHeader("origin", "test")
+	ret, err := runner.runFilter(time.Now(), pack)
+	if nil != err {
+		t.Fatal(err)
+	}
+	if v, _ := ret.Header("filtered"); "true" != v {
+		t.Fatal("Filter result not match")
+	}
+	if v, _ := ret.Header("origin"); "test" != v || "/test" != ret.Topic() {
+		t.Fatal("Filter result should copy headers and topic")
+	}
+
+	filter.err = errors.New("test error")
+	if _, err := runner.runFilter(time.Now(), pack); nil == err {
+		t.Fatal("Should return error")
+	}
+	if 1 != runner.counter.Handled() || 1 != runner.counter.Errors() {
+		t.Fatalf("Counter not match, handled: %d, errors: %d", runner.counter.Handled(), runner.counter.Errors())
+	}
+}
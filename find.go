@@ -16,40 +16,40 @@ const (
 )
 
 // ifCoreComponentConfig 返回此配置是否为核心组件的配置
-func ifCoreComponentConfig(configName string, config conf.Map) (int, string, bool) {
+func (slf *GoPipeline) ifCoreComponentConfig(configName string, config conf.Map) (int, string, bool) {
 	// 1. 先直接查找其配置名
-	if plgType, typeName, ok := ifHasFactoryFuncOfType(configName); ok {
+	if plgType, typeName, ok := slf.ifHasFactoryFuncOfType(configName); ok {
 		return plgType, typeName, true
 	}
 	// 2. 再查找其plugin字段
 	if pluginName, hit := config[componentTypeFieldName]; !hit {
 		return componentUnknown, "", false
 	} else {
-		return ifHasFactoryFuncOfType(pluginName.(string))
+		return slf.ifHasFactoryFuncOfType(pluginName.(string))
 	}
 }
 
 // 是否为已经注册的插件类型名
-func ifHasFactoryFuncOfType(typeName string) (int, string, bool) {
-	if _, hit := SharedRouter().factoryInputs[typeName]; hit {
+func (slf *GoPipeline) ifHasFactoryFuncOfType(typeName string) (int, string, bool) {
+	if _, hit := slf.factoryInputs[typeName]; hit {
 		return componentInput, typeName, true
 	}
 
-	if _, hit := SharedRouter().factoryFilters[typeName]; hit {
+	if _, hit := slf.factoryFilters[typeName]; hit {
 		return componentFilter, typeName, true
 	}
 
-	if _, hit := SharedRouter().factoryOutputs[typeName]; hit {
+	if _, hit := slf.factoryOutputs[typeName]; hit {
 		return componentOutput, typeName, true
 	}
 	return componentUnknown, "", false
 }
 
-func findNonNilDecoder(input Input, config *ComponentConfig, pluginName string) Decoder {
+func (slf *GoPipeline) findNonNilDecoder(input Input, config *ComponentConfig, pluginName string) Decoder {
 	const notFound = "Decoder: <%s> sets but not found, for Output: <%s>"
 
 	if 0 < len(config.DecoderName) {
-		if decoder := SharedRouter().decoders[config.DecoderName]; decoder != nil {
+		if decoder := slf.decoders[config.DecoderName]; decoder != nil {
 			return decoder
 		} else {
 			log.Panic().Msgf(notFound, config.DecoderName, pluginName)
@@ -62,7 +62,7 @@ func findNonNilDecoder(input Input, config *ComponentConfig, pluginName string)
 		switch defaultDecoder.(type) {
 		case string:
 			typeName := defaultDecoder.(string)
-			defDecoder := SharedRouter().decoders[typeName]
+			defDecoder := slf.decoders[typeName]
 			if nil == defDecoder {
 				log.Panic().Msgf(notFound, typeName, pluginName)
 			}
@@ -78,10 +78,10 @@ func findNonNilDecoder(input Input, config *ComponentConfig, pluginName string)
 
 	log.Info().Msgf("Decoder is NOT SET for Input: <%s>, use default.", pluginName)
 	// 默认Decoder为JSONDecoder
-	return SharedRouter().decoders[TypeNameJSONDecoder]
+	return slf.decoders[TypeNameJSONDecoder]
 }
 
-func findNonNilMatcher(plugin VirtualSlot, conf *ComponentConfig) Matcher {
+func (slf *GoPipeline) findNonNilMatcher(plugin VirtualSlot, conf *ComponentConfig) Matcher {
 	// 首先检查Topic字段是否配置
 	if "" != conf.Topic {
 		// Match any messages
@@ -89,7 +89,7 @@ func findNonNilMatcher(plugin VirtualSlot, conf *ComponentConfig) Matcher {
 			return new(AnyMatcher)
 		}
 		// By URL
-		matcher, err := NewDefaultURLMatcher(conf.Topic)
+		matcher, err := newDefaultURLMatcher(conf.Topic, slf.debugConfig.RoutingTrace)
 		if nil != err {
 			log.Panic().Err(err).Msgf("Parse matcher from topic FAILED, topic: ", conf.Topic)
 		}
@@ -104,7 +104,7 @@ func findNonNilMatcher(plugin VirtualSlot, conf *ComponentConfig) Matcher {
 		switch defaultMatcher.(type) {
 		case string:
 			typeName := defaultMatcher.(string)
-			matcher := SharedRouter().matchers[typeName]
+			matcher := slf.matchers[typeName]
 			if nil == matcher {
 				log.Panic().Msgf("Matcher: <%s> sets but NOT FOUND, for Output: <%s>", typeName, plgName)
 			}
@@ -0,0 +1,98 @@
+package gopl
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 组件健康检查：汇总实现 HealthChecker 接口的组件状态，用于存活和就绪检查
+//
+
+// 组件健康检查接口。Input、Filter、Output可以选择实现。
+type HealthChecker interface {
+	// 检查组件的健康状态。返回nil表示健康，否则返回不健康的原因。
+	// 健康检查由Http请求触发，需要快速返回，不可阻塞。
+	CheckHealth() error
+}
+
+// Pipeline健康状态
+const (
+	HealthOK          = "ok"          // 运行中，所有组件健康
+	HealthUnhealthy   = "unhealthy"   // 运行中，存在不健康的组件
+	HealthUnavailable = "unavailable" // 未启动完成，或者正在停止
+)
+
+// 组件的健康状态
+type ComponentHealth struct {
+	ConfigKey string `json:"config_key"`      // 组件配置名
+	Kind      string `json:"kind"`            // 组件类别：input/filter/output
+	State     string `json:"state"`           // 运行状态：running/paused/disabled
+	Healthy   bool   `json:"healthy"`         // 是否健康
+	Error     string `json:"error,omitempty"` // 不健康的原因
+}
+
+// Pipeline的健康报告
+type HealthReport struct {
+	Status     string            `json:"status"`     // ok/unhealthy/unavailable
+	Live       bool              `json:"live"`       // 是否存活：Pipeline未停止
+	Ready      bool              `json:"ready"`      // 是否就绪：启动完成，且运行中的组件全部健康
+	Components []ComponentHealth `json:"components"` // 实现 HealthChecker 接口的组件
+}
+
+// Health 返回默认Pipeline实例的健康报告
+func Health() HealthReport {
+	return SharedRouter().Health()
+}
+
+// Health 返回Pipeline实例的健康报告。被暂停或者停止的组件仍然报告状态，但不影响就绪状态。
+// 组件列表读取自当前的路由快照，不等待Reload和Shutdown。
+func (slf *GoPipeline) Health() HealthReport {
+	report := HealthReport{
+		Status:     HealthUnavailable,
+		Live:       !slf.stopped.Get(),
+		Components: make([]ComponentHealth, 0),
+	}
+	healthy := true
+	if snap, ok := slf.snapshot.Load().(*routeSnapshot); ok {
+		for _, runner := range snap.components {
+			health, ok := checkComponentHealth(runner)
+			if !ok {
+				continue
+			}
+			if !health.Healthy && ComponentRunning == health.State {
+				healthy = false
+			}
+			report.Components = append(report.Components, health)
+		}
+	}
+	if slf.running.Get() && report.Live {
+		report.Ready = healthy
+		if healthy {
+			report.Status = HealthOK
+		} else {
+			report.Status = HealthUnhealthy
+		}
+	}
+	return report
+}
+
+// 检查组件的健康状态。组件未实现 HealthChecker 接口时，返回false
+func checkComponentHealth(runner interface{}) (ComponentHealth, bool) {
+	var slot interface{}
+	health := ComponentHealth{ConfigKey: runnerConfigKey(runner)}
+	switch r := runner.(type) {
+	case *inputRunner:
+		slot, health.Kind, health.State = r.input, "input", r.status()
+	case *filterRunner:
+		slot, health.Kind, health.State = r.filter, "filter", r.status()
+	case *outputRunner:
+		slot, health.Kind, health.State = r.output, "output", r.status()
+	}
+	checker, ok := slot.(HealthChecker)
+	if !ok {
+		return health, false
+	}
+	if err := checker.CheckHealth(); nil != err {
+		health.Error = err.Error()
+	} else {
+		health.Healthy = true
+	}
+	return health, true
+}
@@ -0,0 +1,65 @@
+package gopl
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+type testHealthOutput struct {
+	AbcSlot
+	down int32
+}
+
+func (slf *testHealthOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testHealthOutput) CheckHealth() error {
+	if 1 == atomic.LoadInt32(&slf.down) {
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func TestGoPipeline_Health(t *testing.T) {
+	router := newRouter(1)
+	output := new(testHealthOutput)
+	output.SetName("HealthOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "HealthOutput")
+	router.outputRunners.PushBack(runner)
+	router.outputRunners.PushBack(newOutputRunner(new(testFailOutput), new(AnyMatcher), &ComponentConfig{}, "FailOutput"))
+	router.buildRouteTable()
+
+	// 未启动完成时不就绪
+	if report := router.Health(); !report.Live || report.Ready || HealthUnavailable != report.Status {
+		t.Fatalf("Should not be ready before startup, was: %+v", report)
+	}
+	router.running.Set(true)
+	report := router.Health()
+	if !report.Ready || HealthOK != report.Status || 1 != len(report.Components) {
+		t.Fatalf("Should be ready, was: %+v", report)
+	}
+	if c := report.Components[0]; "HealthOutput" != c.ConfigKey || "output" != c.Kind || !c.Healthy {
+		t.Fatalf("Component health not match, was: %+v", c)
+	}
+
+	atomic.StoreInt32(&output.down, 1)
+	report = router.Health()
+	if !report.Live || report.Ready || HealthUnhealthy != report.Status || "broker unavailable" != report.Components[0].Error {
+		t.Fatalf("Should be unhealthy, was: %+v", report)
+	}
+	// 被暂停的组件不影响就绪状态
+	runner.setPaused(true)
+	if report = router.Health(); !report.Ready || ComponentPaused != report.Components[0].State {
+		t.Fatalf("Paused component should not affect readiness, was: %+v", report)
+	}
+
+	router.stopped.Set(true)
+	if report = router.Health(); report.Live || report.Ready {
+		t.Fatalf("Should not be live after stopped, was: %+v", report)
+	}
+}
@@ -23,10 +23,18 @@ func TPSReporterTerminateHook() {
 	inCNT := float64(stats.Inbounds())
 	fiCNT := float64(stats.Filtered())
 	otCNT := float64(stats.Outbounds())
+	exCNT := float64(stats.Expired())
+	rjCNT := float64(stats.Rejected())
+	spCNT := float64(stats.Spilled())
+	dlCNT := float64(stats.DeadLetters())
 
 	log.Info().Msgf("Uptime[INBOUNDS] CNT: %s, TPS: %s", tps.Format(inCNT), tps.Format(inCNT/sec))
 	log.Info().Msgf("Uptime[FILTERED] CNT: %s, TPS: %s", tps.Format(fiCNT), tps.Format(fiCNT/sec))
 	log.Info().Msgf("Uptime[OUTBOUND] CNT: %s, TPS: %s", tps.Format(otCNT), tps.Format(otCNT/sec))
+	log.Info().Msgf("Uptime[EXPIRED] CNT: %s", tps.Format(exCNT))
+	log.Info().Msgf("Uptime[REJECTED] CNT: %s", tps.Format(rjCNT))
+	log.Info().Msgf("Uptime[SPILLED] CNT: %s", tps.Format(spCNT))
+	log.Info().Msgf("Uptime[DEADLETTER] CNT: %s", tps.Format(dlCNT))
 
 	log.Info().Msgf("Started at: %s", gopl.StartupTime())
 	log.Info().Msgf("Stopped at: %s", time.Now())
@@ -0,0 +1,53 @@
+package http
+
+import (
+	"errors"
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline"
+	"net/http"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 存活和就绪检查接口：汇总组件的健康状态，供编排系统探测
+//
+
+// Http服务未运行时，依赖Http服务的组件返回的错误
+var ErrServerNotRunning = errors.New("http server is not running")
+
+// 注册存活检查和就绪检查接口，报告Http服务所属Pipeline实例的健康状态
+func (slf *Server) registerHealthHandlers(healthPath, readyPath string) {
+	slf.RegisterHandler("GET", healthPath, func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		report := slf.pipeline.Health()
+		writeHealthReport(resp, report, report.Live)
+	})
+	slf.RegisterHandler("GET", readyPath, func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+		report := slf.pipeline.Health()
+		writeHealthReport(resp, report, report.Ready)
+	})
+}
+
+func writeHealthReport(resp http.ResponseWriter, report gopl.HealthReport, ok bool) {
+	data, err := gopl.MarshalJSON(report)
+	if nil != err {
+		log.Error().Str("tag", "HttpServerHook").Err(err).Msg("Encode health report FAILED")
+		resp.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if ok {
+		resp.WriteHeader(http.StatusOK)
+	} else {
+		resp.WriteHeader(http.StatusServiceUnavailable)
+	}
+	resp.Write(data)
+}
+
+// 检查Pipeline实例的Http服务是否运行中
+func checkServerHealth(pipeline *gopl.GoPipeline) error {
+	if !ServerOf(pipeline).IsRunning() {
+		return ErrServerNotRunning
+	}
+	return nil
+}
@@ -35,11 +35,16 @@ func (slf *GoPLHttpServerInput) Init(args conf.Map) {
 	slf.responseFailed = args.GetStringOrDefault("response_failed", `{"message": "%s", "status": "fail"}`)
 }
 
+// 检查Http服务是否运行中
+func (slf *GoPLHttpServerInput) CheckHealth() error {
+	return checkServerHealth(slf.Pipeline())
+}
+
 func (slf *GoPLHttpServerInput) Input(deliverer gopl.Deliverer, decoder gopl.Decoder) {
 	defer slf.SetTerminated()
 
 	// 处理每个Http请求
-	RegisterHandler("POST", slf.pathUri, func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	ServerOf(slf.Pipeline()).RegisterHandler("POST", slf.pathUri, func(resp http.ResponseWriter, req *http.Request, _ httprouter.Params) {
 		defer req.Body.Close()
 
 		resp.Header().Set("X-Server", "GoPipeline/HttpServerInput")
@@ -79,10 +84,16 @@ func (slf *GoPLHttpServerInput) Input(deliverer gopl.Deliverer, decoder gopl.Dec
 			pack, err := decoder.Decode(bytes)
 			if nil != err {
 				slf.TagLog(log.Error).Err(err).Str("body", string(bytes)).Msg("Decode body FAILED")
+				gopl.DeliverDeadLetter(deliverer, bytes, err)
 				sendResponseFailed(err.Error())
 				return
 			}
-			deliverer.Deliver(pack)
+			// Router过载，拒绝消息时响应503
+			if err := gopl.TryDeliver(deliverer, pack); nil != err {
+				resp.WriteHeader(http.StatusServiceUnavailable)
+				resp.Write([]byte(fmt.Sprintf(slf.responseFailed, err.Error())))
+				return
+			}
 		}
 
 		resp.WriteHeader(http.StatusOK)
@@ -94,4 +105,6 @@ func (slf *GoPLHttpServerInput) Input(deliverer gopl.Deliverer, decoder gopl.Dec
 	slf.TagLog(log.Info).Msgf("Register http handler on: %s", slf.pathUri)
 	// 保持持续运行，监听Shutdown信号
 	<-slf.ShutdownChan()
+	// 移除处理函数，重新加载后的Input可以使用相同的路径
+	ServerOf(slf.Pipeline()).UnregisterHandler("POST", slf.pathUri)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/yoojia/go-http"
 	"github.com/yoojia/go-pipeline"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -13,23 +14,83 @@ import (
 // Author: 陈哈哈 chenyongjia@parkingwang.com, yoojiachen@gmail.com
 //
 
-var gHttpServer = httpd.NewHttpServer()
-var gHttpRouter = httpd.NewHttpRouter()
+// Server Pipeline实例的Http服务。每个Pipeline实例拥有独立的Http服务、路由和处理函数，
+// 在同一进程中运行多个Pipeline实例时，互不影响。
+type Server struct {
+	pipeline *gopl.GoPipeline
+	server   *httpd.HttpServer
+	router   *httpd.HttpRouter
 
-// HttpRouter 返回用于注册Http处理函数路由的对象。
+	// 已注册的处理函数。httprouter不支持移除或者替换路由，每个Method+Pattern只向路由注册一次转发函数，
+	// 由转发函数查找当前的处理函数。组件重新加载时可以替换或者移除处理函数。
+	mu       *sync.RWMutex
+	handlers map[string]httprouter.Handle
+	routes   map[string]bool
+}
+
+// Pipeline实例 -> *Server
+var gServers = new(sync.Map)
+
+// ServerOf 返回Pipeline实例的Http服务，首次获取时创建。
+func ServerOf(pipeline *gopl.GoPipeline) *Server {
+	if server, ok := gServers.Load(pipeline); ok {
+		return server.(*Server)
+	}
+	server, _ := gServers.LoadOrStore(pipeline, &Server{
+		pipeline: pipeline,
+		server:   httpd.NewHttpServer(),
+		router:   httpd.NewHttpRouter(),
+		mu:       new(sync.RWMutex),
+		handlers: make(map[string]httprouter.Handle),
+		routes:   make(map[string]bool),
+	})
+	return server.(*Server)
+}
+
+// Router 返回用于注册Http处理函数路由的对象。
 // 注意：获取路由对象时，Http服务不一定处于运行状态。
 // 在非运行状态下注册的路由信息，需要等待Http服务运行后才生效。
-func HttpRouter() *httpd.HttpRouter {
-	return gHttpRouter
+func (slf *Server) Router() *httpd.HttpRouter {
+	return slf.router
 }
 
-// RegisterHandler 用来注册Http处理函数，指定Pattern
-func RegisterHandler(method, pattern string, handler httprouter.Handle) {
-	HttpRouter().Handle(method, pattern, handler)
+// RegisterHandler 用来注册Http处理函数，指定Pattern。重复注册时，替换之前的处理函数。
+func (slf *Server) RegisterHandler(method, pattern string, handler httprouter.Handle) {
+	key := method + " " + pattern
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.handlers[key] = handler
+	if slf.routes[key] {
+		return
+	}
+	slf.routes[key] = true
+	slf.router.Handle(method, pattern, func(resp http.ResponseWriter, req *http.Request, params httprouter.Params) {
+		slf.mu.RLock()
+		handler, ok := slf.handlers[key]
+		slf.mu.RUnlock()
+		if ok {
+			handler(resp, req, params)
+		} else {
+			http.NotFound(resp, req)
+		}
+	})
 }
 
-func ServerStartupHook() {
-	httpConfig, hit := gopl.FindConfigOnRoot("GoPLHttpServer")
+// UnregisterHandler 移除Http处理函数。移除后，请求此Pattern时响应404。
+func (slf *Server) UnregisterHandler(method, pattern string) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	delete(slf.handlers, method+" "+pattern)
+}
+
+// IsRunning 返回Http服务是否运行中
+func (slf *Server) IsRunning() bool {
+	return slf.server.IsRunning()
+}
+
+// Startup 启动Http服务。读取Pipeline实例的配置，健康检查接口报告此实例的状态。
+func (slf *Server) Startup() {
+	httpConfig, hit := slf.pipeline.FindConfigOnRoot("GoPLHttpServer")
 	if !hit {
 		return
 	}
@@ -38,6 +99,11 @@ func ServerStartupHook() {
 	}
 
 	address := httpConfig.GetStringOrDefault("address", ":18880")
+	if httpConfig.GetBoolOrDefault("health_enabled", false) {
+		slf.registerHealthHandlers(
+			httpConfig.GetStringOrDefault("health_path", "/healthz"),
+			httpConfig.GetStringOrDefault("ready_path", "/readyz"))
+	}
 
 	log.Info().Str("tag", "HttpServerHook").Msgf("Start Http Server, address: %s", address)
 	go func() {
@@ -46,10 +112,10 @@ func ServerStartupHook() {
 			log.Info().Str("tag", "HttpServerHook").Msg("Http Server: Auth ENABLED")
 			authApps := LoadAuthorizedApps(httpConfig)
 			authTTL := httpConfig.GetDurationOrDefault("auth_keep_ttl", time.Minute*10)
-			HttpRouter().UseInterceptor(NewAuthMiddleware(authTTL, authApps))
+			slf.router.UseInterceptor(NewAuthMiddleware(authTTL, authApps))
 		}
 
-		err := gHttpServer.Start(address, HttpRouter().Route())
+		err := slf.server.Start(address, slf.router.Route())
 		if nil != err {
 			if err != http.ErrServerClosed {
 				log.Fatal().Err(err).Msg("Failed to start http server")
@@ -62,10 +128,46 @@ func ServerStartupHook() {
 	<-time.After(time.Millisecond)
 }
 
-func ServerTerminateHook() {
-	if gHttpServer.IsRunning() {
-		if err := gHttpServer.Shutdown(); nil != err {
+// Shutdown 停止Http服务
+func (slf *Server) Shutdown() {
+	if slf.server.IsRunning() {
+		if err := slf.server.Shutdown(); nil != err {
 			log.Fatal().Err(err).Msg("Failed to shutdown http server")
 		}
 	}
 }
+
+// HttpRouter 返回默认Pipeline实例的Http路由对象。
+func HttpRouter() *httpd.HttpRouter {
+	return ServerOf(gopl.SharedRouter()).Router()
+}
+
+// RegisterHandler 向默认Pipeline实例的Http服务注册处理函数。
+func RegisterHandler(method, pattern string, handler httprouter.Handle) {
+	ServerOf(gopl.SharedRouter()).RegisterHandler(method, pattern, handler)
+}
+
+// UnregisterHandler 移除默认Pipeline实例的Http处理函数。
+func UnregisterHandler(method, pattern string) {
+	ServerOf(gopl.SharedRouter()).UnregisterHandler(method, pattern)
+}
+
+// ServerStartupHook 启动默认Pipeline实例的Http服务
+func ServerStartupHook() {
+	ServerOf(gopl.SharedRouter()).Startup()
+}
+
+// NewServerStartupHook 返回启动指定Pipeline实例Http服务的Hook。
+func NewServerStartupHook(pipeline *gopl.GoPipeline) gopl.HookFunc {
+	return ServerOf(pipeline).Startup
+}
+
+// ServerTerminateHook 停止默认Pipeline实例的Http服务
+func ServerTerminateHook() {
+	ServerOf(gopl.SharedRouter()).Shutdown()
+}
+
+// NewServerTerminateHook 返回停止指定Pipeline实例Http服务的Hook。
+func NewServerTerminateHook(pipeline *gopl.GoPipeline) gopl.HookFunc {
+	return ServerOf(pipeline).Shutdown
+}
@@ -5,6 +5,7 @@ package http
 //
 
 import (
+	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/parkingwang/go-conf"
 	"github.com/parkingwang/go-sign"
@@ -12,6 +13,7 @@ import (
 	"github.com/yoojia/go-pipeline"
 	"github.com/yoojia/go-pipeline/abc"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,6 +28,8 @@ type GoPLWebSocketClientInput struct {
 	authEnabled             bool
 	authAppKey              string
 	authAppSecret           string
+	responseRejected        string // Router拒绝消息时，回复给服务端的消息
+	connected               int32  // 是否已连接到服务端
 }
 
 func (slf *GoPLWebSocketClientInput) Init(args conf.Map) {
@@ -39,6 +43,7 @@ func (slf *GoPLWebSocketClientInput) Init(args conf.Map) {
 	slf.authEnabled = args.MustBool("auth_enabled")
 	slf.authAppKey = args.MustString("auth_app_key")
 	slf.authAppSecret = args.MustString("auth_app_secret")
+	slf.responseRejected = args.GetStringOrDefault("response_rejected", `{"message": "%s", "status": "rejected"}`)
 
 	if slf.authEnabled {
 		if "" == slf.authAppKey || "" == slf.authAppSecret {
@@ -60,9 +65,11 @@ func (slf *GoPLWebSocketClientInput) Input(deliverer gopl.Deliverer, decoder gop
 		slf.TagLog(log.Error).Err(err).Msgf("Dial to server: %s FAILED", initUrl)
 	} else {
 		slf.TagLog(log.Info).Msgf("Dial to server: %s SUCCESS", initUrl)
+		slf.setConnected(true)
 	}
 
 	defer func() {
+		slf.setConnected(false)
 		if nil != cli {
 			cli.Close()
 		}
@@ -85,6 +92,7 @@ func (slf *GoPLWebSocketClientInput) Input(deliverer gopl.Deliverer, decoder gop
 					slf.TagLog(log.Error).Err(err).Msg("Redial to server: FAILED")
 				} else {
 					slf.TagLog(log.Info).Msgf("Redial to server: %s SUCCESS", reconnectUrl)
+					slf.setConnected(true)
 				}
 			}
 
@@ -95,11 +103,19 @@ func (slf *GoPLWebSocketClientInput) Input(deliverer gopl.Deliverer, decoder gop
 					slf.TagLog(log.Error).Err(err).Msgf("Read from server: %s FAILED", slf.serverPath)
 					cli.Close()
 					cli = nil
+					slf.setConnected(false)
 				} else {
 					if msg, err := decoder.Decode(bytes); nil != err {
 						slf.TagLog(log.Error).Err(err).Str("bytes", string(bytes)).Msgf("Decode ws bytes FAILED")
+						gopl.DeliverDeadLetter(deliverer, bytes, err)
 					} else {
-						deliverer.Deliver(msg)
+						if err := gopl.TryDeliver(deliverer, msg); nil != err {
+							// Router过载，通知服务端消息被拒绝
+							reply := fmt.Sprintf(slf.responseRejected, err.Error())
+							if err := cli.WriteMessage(websocket.TextMessage, []byte(reply)); nil != err {
+								slf.TagLog(log.Error).Err(err).Msg("Write rejected message FAILED")
+							}
+						}
 					}
 				}
 			}
@@ -107,6 +123,22 @@ func (slf *GoPLWebSocketClientInput) Input(deliverer gopl.Deliverer, decoder gop
 	}
 }
 
+// 检查是否已连接到服务端。连接断开期间，等待重连的Input报告为不健康。
+func (slf *GoPLWebSocketClientInput) CheckHealth() error {
+	if 0 == atomic.LoadInt32(&slf.connected) {
+		return fmt.Errorf("disconnected from server: %s", slf.serverPath)
+	}
+	return nil
+}
+
+func (slf *GoPLWebSocketClientInput) setConnected(connected bool) {
+	if connected {
+		atomic.StoreInt32(&slf.connected, 1)
+	} else {
+		atomic.StoreInt32(&slf.connected, 0)
+	}
+}
+
 func (slf *GoPLWebSocketClientInput) makeWSUrl() string {
 	url := slf.serverPath
 	if slf.authEnabled {
@@ -91,10 +91,15 @@ func (slf *GoPLWebSocketServerOutput) Output(pack *gopl.DataFrame) {
 	}
 }
 
+// 检查Http服务是否运行中
+func (slf *GoPLWebSocketServerOutput) CheckHealth() error {
+	return checkServerHealth(slf.Pipeline())
+}
+
 func (slf *GoPLWebSocketServerOutput) onServe() {
 	defer slf.SetTerminated()
 
-	RegisterHandler("GET", slf.pathUri, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	ServerOf(slf.Pipeline()).RegisterHandler("GET", slf.pathUri, func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		if conn, err := slf.upgrader.Upgrade(w, r, nil); err != nil {
 			slf.TagLog(log.Error).Err(err).Msgf("Upgrade WS protocol FAILED: %s", r.RemoteAddr)
 		} else {
@@ -104,6 +109,7 @@ func (slf *GoPLWebSocketServerOutput) onServe() {
 	slf.TagLog(log.Info).Msgf("Register web-socket serve on: %s", slf.pathUri)
 
 	<-slf.ShutdownChan()
+	ServerOf(slf.Pipeline()).UnregisterHandler("GET", slf.pathUri)
 	atomic.StoreInt32(&slf.cliNowCount, math.MinInt32)
 	slf.TagLog(log.Info).Msgf("Shutdown, close sessions...")
 	slf.forEachClients(func(addr string, cli *WsSession) {
@@ -0,0 +1,258 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline/spool"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Router消息入口队列：根据背压策略，处理Router过载时投递的消息
+//
+
+const (
+	BackpressureBlock      = "block"       // 阻塞Input，直到Router可以处理消息
+	BackpressureReject     = "reject"      // 拒绝新消息
+	BackpressureDropOldest = "drop_oldest" // 丢弃入口队列中最早的消息
+	BackpressureSpill      = "spill"       // 将新消息写入磁盘队列，Router空闲时再读取处理
+
+	defaultMaxPending = 1024
+)
+
+type ingress struct {
+	policy string
+	frames chan *DataFrame
+	spool  *spool.Queue // 仅spill策略使用
+
+	committer *spoolCommitter // 磁盘队列中的消息处理完成后确认
+	backlog   int64           // 磁盘队列中未放入入口队列的消息数量
+
+	handler func(pack *DataFrame) // 将消息派发到协程池
+	counter *FioCounter
+	verbose bool
+
+	mu       *sync.RWMutex
+	closed   bool
+	stop     chan struct{}
+	wg       *sync.WaitGroup // 派发协程
+	replayWg *sync.WaitGroup // 回放协程，以及等待放入入口队列的消息
+}
+
+// 创建入口队列。block策略不需要入口队列，返回nil。
+func newIngress(config RouterConfig, counter *FioCounter, verbose bool) *ingress {
+	switch config.Backpressure {
+	case "", BackpressureBlock:
+		return nil
+	case BackpressureReject, BackpressureDropOldest, BackpressureSpill:
+	default:
+		withTag(log.Panic).Msgf("Invalid backpressure: <%s>", config.Backpressure)
+	}
+	size := config.MaxPending
+	if 0 >= size {
+		size = defaultMaxPending
+	}
+	in := &ingress{
+		policy:   config.Backpressure,
+		frames:   make(chan *DataFrame, size),
+		counter:  counter,
+		verbose:  verbose,
+		mu:       new(sync.RWMutex),
+		stop:     make(chan struct{}),
+		wg:       new(sync.WaitGroup),
+		replayWg: new(sync.WaitGroup),
+	}
+	if BackpressureSpill == config.Backpressure {
+		if "" == config.SpillDir {
+			withTag(log.Panic).Msg("Backpressure <spill> require <spill_dir>")
+		}
+		queue, err := spool.Open(config.SpillDir, spool.Options{MaxBytes: config.SpillMaxBytes})
+		if nil != err {
+			withTag(log.Panic).Err(err).Msgf("Failed to open spill dir: %s", config.SpillDir)
+		}
+		in.spool = queue
+		in.committer = newSpoolCommitter("spilled", queue)
+		in.backlog = int64(queue.Len())
+		if remains := queue.Len(); 0 < remains {
+			withTag(log.Info).Msgf("Recover spilled frames: %d, dir: %s", remains, config.SpillDir)
+		}
+	}
+	return in
+}
+
+// 启动派发协程。spill策略同时启动磁盘队列的回放协程。
+func (slf *ingress) start(handler func(pack *DataFrame)) {
+	slf.handler = handler
+	slf.wg.Add(1)
+	go func() {
+		defer slf.wg.Done()
+		for pack := range slf.frames {
+			slf.handler(pack)
+		}
+	}()
+	if nil != slf.spool {
+		slf.replayWg.Add(1)
+		go slf.replay()
+	}
+}
+
+// 投递消息。按背压策略无法接收消息时，消息被释放，并返回 ErrDeliverRejected
+func (slf *ingress) offer(pack *DataFrame) error {
+	slf.mu.RLock()
+	defer slf.mu.RUnlock()
+	if slf.closed {
+		slf.reject(pack)
+		return ErrDeliverRejected
+	}
+	switch slf.policy {
+	case BackpressureDropOldest:
+		for {
+			select {
+			case slf.frames <- pack:
+				return nil
+			default:
+			}
+			select {
+			case old := <-slf.frames:
+				slf.reject(old)
+			default:
+			}
+		}
+
+	case BackpressureSpill:
+		// 磁盘队列中存在未回放的消息时，新消息也写入磁盘，以保持消息顺序
+		if 0 == atomic.LoadInt64(&slf.backlog) {
+			select {
+			case slf.frames <- pack:
+				return nil
+			default:
+			}
+		}
+		return slf.spill(pack)
+
+	default:
+		select {
+		case slf.frames <- pack:
+			return nil
+		default:
+			slf.reject(pack)
+			return ErrDeliverRejected
+		}
+	}
+}
+
+// 将已持久化的消息放入入口队列。入口队列已满时等待，不按背压策略拒绝或者丢弃；
+// 停止时消息被放弃处理，确认回调收到 ErrPipelineStopped。
+func (slf *ingress) put(pack *DataFrame, cancel <-chan struct{}) {
+	slf.mu.RLock()
+	closed := slf.closed
+	if !closed {
+		// 与回放协程相同，关闭入口队列前等待放入完成
+		slf.replayWg.Add(1)
+	}
+	slf.mu.RUnlock()
+	if !closed {
+		defer slf.replayWg.Done()
+		select {
+		case slf.frames <- pack:
+			return
+		case <-slf.stop:
+		case <-cancel:
+		}
+	}
+	pack.fail(ErrPipelineStopped)
+	releaseDataFrame(pack)
+}
+
+func (slf *ingress) reject(pack *DataFrame) {
+	pack.fail(ErrDeliverRejected)
+	slf.counter.increaseRejected()
+	if slf.verbose {
+		withTag(log.Debug).Msgf("Deliver REJECTED, backpressure: %s, sender: %s", slf.policy, pack.Sender())
+	}
+	releaseDataFrame(pack)
+}
+
+// 将消息写入磁盘队列
+func (slf *ingress) spill(pack *DataFrame) error {
+	data, err := encodeDataFrame(pack)
+	if nil != err {
+		withTag(log.Error).Err(err).Msgf("Encode frame FAILED, sender: %s", pack.Sender())
+		slf.reject(pack)
+		return ErrDeliverRejected
+	}
+	if _, err := slf.spool.Append(data); nil != err {
+		if spool.ErrFull != err {
+			withTag(log.Error).Err(err).Msg("Spill frame FAILED")
+		}
+		slf.reject(pack)
+		return ErrDeliverRejected
+	}
+	atomic.AddInt64(&slf.backlog, 1)
+	slf.counter.increaseSpilled()
+	releaseDataFrame(pack)
+	return nil
+}
+
+// 读取磁盘队列中的消息，放入入口队列。消息处理完成后才确认，进程崩溃时未处理完成的消息在下次启动时恢复。
+func (slf *ingress) replay() {
+	defer slf.replayWg.Done()
+	for {
+		offset, data, err := slf.spool.Next()
+		if io.EOF == err {
+			select {
+			case <-slf.stop:
+				return
+			case <-slf.spool.Signal():
+			}
+			continue
+		}
+		if nil != err {
+			withTag(log.Error).Err(err).Msg("Read spilled frame FAILED")
+			return
+		}
+		slf.committer.read(offset)
+		pack, err := decodeDataFrame(data)
+		if nil != err {
+			withTag(log.Error).Err(err).Msgf("Decode spilled frame FAILED, offset: %d", offset)
+			atomic.AddInt64(&slf.backlog, -1)
+			slf.committer.commit(offset)
+			continue
+		}
+		slf.committer.track(pack, offset)
+		select {
+		case slf.frames <- pack:
+			atomic.AddInt64(&slf.backlog, -1)
+		case <-slf.stop:
+			// 未放入入口队列的消息不确认，保留在磁盘中，下次启动时恢复
+			pack.ack = nil
+			releaseDataFrame(pack)
+			return
+		}
+	}
+}
+
+// 停止接收消息，等待入口队列中的消息派发完成
+func (slf *ingress) close() {
+	slf.mu.Lock()
+	slf.closed = true
+	slf.mu.Unlock()
+	close(slf.stop)
+	// 等待回放协程退出后，再关闭入口队列
+	slf.replayWg.Wait()
+	close(slf.frames)
+	slf.wg.Wait()
+}
+
+// 关闭磁盘队列。在Router停止后调用，已派发的消息处理完成后得到确认。
+func (slf *ingress) closeSpool() {
+	if nil == slf.spool {
+		return
+	}
+	if remains := slf.spool.Len(); 0 < remains {
+		withTag(log.Info).Msgf("Spilled frames remains: %d, will recover on next startup", remains)
+	}
+	slf.spool.Close()
+}
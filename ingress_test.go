@@ -0,0 +1,96 @@
+package gopl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func TestIngress_Reject(t *testing.T) {
+	in := newIngress(RouterConfig{Backpressure: BackpressureReject, MaxPending: 2}, new(FioCounter), false)
+	// 未启动派发协程，第3个消息开始被拒绝
+	for i := 0; i < 2; i++ {
+		if err := in.offer(NewDataFrame()); nil != err {
+			t.Fatalf("Should accept, was: %s", err)
+		}
+	}
+	if err := in.offer(NewDataFrame()); ErrDeliverRejected != err {
+		t.Fatalf("Should reject, was: %s", err)
+	}
+}
+
+func TestIngress_DropOldest(t *testing.T) {
+	in := newIngress(RouterConfig{Backpressure: BackpressureDropOldest, MaxPending: 2}, new(FioCounter), false)
+	frames := []*DataFrame{NewDataFrame(), NewDataFrame(), NewDataFrame()}
+	for _, f := range frames {
+		if err := in.offer(f); nil != err {
+			t.Fatalf("Should accept, was: %s", err)
+		}
+	}
+	if frames[1] != <-in.frames || frames[2] != <-in.frames {
+		t.Fatal("Oldest frame should be dropped")
+	}
+}
+
+func TestIngress_Spill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-ingress")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	in := newIngress(RouterConfig{Backpressure: BackpressureSpill, MaxPending: 1, SpillDir: dir}, new(FioCounter), false)
+	deadline := time.Now().Add(time.Hour)
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		pack := NewDataFrame()
+		pack.setTopic(topic)
+		pack.SetHeader("Origin", "TestInput")
+		pack.SetDeadline(deadline)
+		pack.SetBody(bytes.NewBufferString(topic))
+		if err := in.offer(pack); nil != err {
+			t.Fatalf("Should accept, was: %s", err)
+		}
+	}
+	if 2 != in.spool.Len() {
+		t.Fatalf("Spilled frames not match, was: %d", in.spool.Len())
+	}
+
+	received := make(chan *DataFrame, 3)
+	frames := make([]*DataFrame, 0)
+	in.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		select {
+		case pack := <-received:
+			frames = append(frames, pack)
+			body, _ := pack.ReadBytes()
+			if topic != pack.Topic() || topic != string(body) || "TestInput" != pack.HeaderOrDefault("Origin", "") {
+				t.Fatalf("Replay frame not match, topic: %s", pack.Topic())
+			}
+			if d, ok := pack.Deadline(); !ok || !d.Equal(time.Unix(0, deadline.UnixNano())) {
+				t.Fatalf("Replay deadline not match, was: %s", d)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Replay frame timeout, topic: %s", topic)
+		}
+	}
+	// 消息处理完成前不确认
+	if 2 != in.spool.Len() {
+		t.Fatalf("Unprocessed frames should not be committed, remains: %d", in.spool.Len())
+	}
+	for _, pack := range frames {
+		releaseDataFrame(pack)
+	}
+	in.close()
+	defer in.closeSpool()
+	if 0 != in.spool.Len() {
+		t.Fatalf("Replayed frames should be committed, remains: %d", in.spool.Len())
+	}
+}
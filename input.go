@@ -1,6 +1,7 @@
 package gopl
 
 import (
+	"errors"
 	"fmt"
 	"github.com/rs/zerolog/log"
 	"time"
@@ -13,12 +14,35 @@ import (
 
 const FieldNameDataFrameHeaders = "DataFrameHeaders" // Input注入消息Header时使用的配置字段名
 
+var (
+	// Router过载时，按背压策略拒绝投递消息返回的错误
+	ErrDeliverRejected = errors.New("deliver rejected: router is overloaded")
+	// Input被管理接口暂停时，投递消息返回的错误
+	ErrInputPaused = errors.New("deliver rejected: input is paused")
+)
+
 // 消息投递接口
 type Deliverer interface {
 	// 发送消息
 	Deliver(msg *DataFrame)
 }
 
+// 可报告投递结果的消息投递接口
+type TryDeliverer interface {
+	Deliverer
+	// 发送消息。Router按背压策略拒绝消息时，返回 ErrDeliverRejected，消息对象已被释放，不可再使用。
+	TryDeliver(msg *DataFrame) error
+}
+
+// TryDeliver 发送消息，并返回投递结果。Deliverer未实现 TryDeliverer 接口时，总是返回nil。
+func TryDeliver(deliverer Deliverer, msg *DataFrame) error {
+	if td, ok := deliverer.(TryDeliverer); ok {
+		return td.TryDeliver(msg)
+	}
+	deliverer.Deliver(msg)
+	return nil
+}
+
 // 消息输入接口
 type Input interface {
 	VirtualSlot
@@ -34,20 +58,31 @@ type inputRunner struct {
 	decoder   Decoder
 	config    *ComponentConfig
 	configKey string
+	timeout   time.Duration // 全局消息处理超时时间
+	limiter   *rateLimiter  // 限流器，未配置时为nil
+
+	*componentState
 }
 
-func newInputRunner(input Input, decoder Decoder, config *ComponentConfig, configKey string) *inputRunner {
+func newInputRunner(input Input, decoder Decoder, config *ComponentConfig, configKey string, timeout time.Duration) *inputRunner {
 	return &inputRunner{
 		input:     input,
 		decoder:   decoder,
 		config:    config,
 		configKey: configKey,
+		timeout:   timeout,
+		limiter:   newRateLimiter(config.RateLimit),
+
+		componentState: newComponentState(),
 	}
 }
 
-func (slf *inputRunner) init() {
+func (slf *inputRunner) init(pipeline *GoPipeline) {
 	pluginName := slf.configKey
 	slf.input.SetName(pluginName)
+	if need, ok := slf.input.(NeedPipeline); ok {
+		need.SetPipeline(pipeline)
+	}
 
 	// Init
 	log.Info().Msgf("Init Input: <%s>, decoder: <%T>", pluginName, slf.decoder)
@@ -55,7 +90,7 @@ func (slf *inputRunner) init() {
 	slf.input.Init(slf.config.InitArgs)
 }
 
-func (slf *inputRunner) start(deliverer Deliverer) {
+func (slf *inputRunner) start(pipeline *GoPipeline) {
 	pluginName := slf.input.GetName()
 	headerValue := slf.config.InitArgs.MustMap(FieldNameDataFrameHeaders)
 	if 0 < len(headerValue) {
@@ -67,10 +102,14 @@ func (slf *inputRunner) start(deliverer Deliverer) {
 	}
 	log.Info().Msgf("Start Input: <%s>", pluginName)
 	proxy := &delivererProxy{
-		realDeliverer: deliverer,
+		pipeline:      pipeline,
 		signer:        pluginName,
 		injectHeaders: headers,
 		injectTopic:   slf.config.Topic,
+		priority:      slf.config.Priority,
+		timeout:       DurationOrDefault(slf.config.DeliverTimeout, slf.timeout),
+		state:         slf.componentState,
+		limiter:       slf.limiter,
 	}
 	slf.input.Input(proxy, slf.decoder)
 }
@@ -78,24 +117,57 @@ func (slf *inputRunner) start(deliverer Deliverer) {
 ////
 
 type delivererProxy struct {
-	realDeliverer Deliverer
+	pipeline      *GoPipeline
 	signer        string
 	injectHeaders Headers
 	injectTopic   string
+	priority      string
+	timeout       time.Duration
+	state         *componentState
+	limiter       *rateLimiter
 }
 
 // 发送消息
 func (slf *delivererProxy) Deliver(pack *DataFrame) {
+	slf.TryDeliver(pack)
+}
+
+// 发送消息，并返回投递结果
+func (slf *delivererProxy) TryDeliver(pack *DataFrame) error {
+	if slf.state.isPaused() {
+		pack.fail(ErrInputPaused)
+		releaseDataFrame(pack)
+		return ErrInputPaused
+	}
 	ts := time.Now()
 	pack.SetHeader("Origin", slf.signer)
 	pack.addTrace(slf.signer, ts.UnixNano())
 	pack.SetHeaders(slf.injectHeaders)
 	pack.setTopic(slf.injectTopic)
-	slf.realDeliverer.Deliver(pack)
+	if "" != slf.priority {
+		if _, set := pack.Header(HeaderPriority); !set {
+			pack.SetHeader(HeaderPriority, slf.priority)
+		}
+	}
+	if 0 < slf.timeout {
+		if _, set := pack.Deadline(); !set {
+			pack.SetDeadline(ts.Add(slf.timeout))
+		}
+	}
+	if nil != slf.limiter && !slf.limiter.take(pack) {
+		slf.pipeline.fio.increaseThrottled()
+		pack.fail(ErrDeliverThrottled)
+		releaseDataFrame(pack)
+		return ErrDeliverThrottled
+	}
+	if err := slf.pipeline.deliverInput(pack); nil != err {
+		return err
+	}
 
 	// Counting and Samples
 	go func() {
-		increaseInbound()
-		sampleInbound(time.Now().Sub(ts).Nanoseconds())
+		slf.pipeline.fio.increaseInbound()
+		slf.pipeline.samples.sampleInbound(time.Now().Sub(ts).Nanoseconds())
 	}()
+	return nil
 }
@@ -1,12 +1,16 @@
 package kafka
 
 import (
+	"context"
 	"github.com/Shopify/sarama"
 	"github.com/parkingwang/go-conf"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	"github.com/yoojia/go-pipeline"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 //
@@ -18,9 +22,20 @@ import (
 type GoPLKafkaProducerOutput struct {
 	gopl.AbcSlot
 
-	messageKey   string // Kafka发送数据时的Key
-	messageTopic string // Kafka发送消息的Topic
-	producer     sarama.AsyncProducer
+	messageKey   string        // Kafka发送数据时的Key
+	messageTopic string        // Kafka发送消息的Topic
+	collected    chan struct{} // 发送结果处理协程结束时关闭
+	healthWindow time.Duration // 最近发送失败的时间在此窗口内时，报告为不健康
+	sendTimeout  time.Duration // 消息没有截止时间时，等待发送结果的最长时间
+
+	// Init在协程中执行，Producer在其它配置完成后最后设置。读取配置前先读取Producer。
+	producer  atomic.Value // sarama.AsyncProducer
+	lastError atomic.Value // 最近一次发送失败 *producerError
+}
+
+type producerError struct {
+	err error
+	at  time.Time
 }
 
 func (slf *GoPLKafkaProducerOutput) Init(args conf.Map) {
@@ -42,9 +57,14 @@ func (slf *GoPLKafkaProducerOutput) Init(args conf.Map) {
 	}
 
 	slf.messageKey = args.MustString("message_key")
+	slf.healthWindow = args.GetDurationOrDefault("health_error_window", time.Second*30)
+	slf.sendTimeout = args.GetDurationOrDefault("send_timeout", time.Second*10)
 
 	config := sarama.NewConfig()
 	config.Producer.Retry.Max = int(args.GetInt64OrDefault("retry_max", 5))
+	// 每个消息的发送结果通过 Successes/Errors 返回，按 Metadata 通知给发送此消息的调用方
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
 
 	switch strings.ToLower(args.MustString("required_acks")) {
 	case "waitforall":
@@ -61,43 +81,127 @@ func (slf *GoPLKafkaProducerOutput) Init(args conf.Map) {
 	if nil != err {
 		slf.TagLog(log.Panic).Err(err).Msgf("Failed to connect to brokers: %s", brokers)
 	} else {
-		slf.producer = prod
+		slf.collected = make(chan struct{})
+		go slf.collect(prod)
+		slf.producer.Store(prod)
+	}
+}
+
+// 读取Producer的发送结果，通知给发送消息的调用方。Producer关闭后结束。
+func (slf *GoPLKafkaProducerOutput) collect(prod sarama.AsyncProducer) {
+	defer close(slf.collected)
+	successes, errs := prod.Successes(), prod.Errors()
+	for nil != successes || nil != errs {
+		select {
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			notifyResult(msg, nil)
+		case perr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			slf.lastError.Store(&producerError{err: perr.Err, at: time.Now()})
+			notifyResult(perr.Msg, perr.Err)
+		}
+	}
+}
+
+// 发送结果写入消息Metadata中的结果通道。通道有缓存，不会阻塞。
+func notifyResult(msg *sarama.ProducerMessage, err error) {
+	if nil == msg {
+		return
+	}
+	if result, ok := msg.Metadata.(chan error); ok {
+		result <- err
 	}
 }
 
 func (slf *GoPLKafkaProducerOutput) Output(pack *gopl.DataFrame) {
+	if err := slf.OutputContext(pack.Context(), pack); nil != err {
+		slf.TagLog(log.Error).Err(err).Msg("Failed to send message to broker")
+	}
+}
 
+func (slf *GoPLKafkaProducerOutput) OutputContext(ctx context.Context, pack *gopl.DataFrame) error {
+	producer, ok := slf.producer.Load().(sarama.AsyncProducer)
+	if !ok {
+		return errors.New("producer is not connected")
+	}
 	topic := pack.HeaderOrDefault("kafka.message.topic", slf.messageTopic)
 	key := pack.HeaderOrDefault("kafka.message.key", slf.messageKey)
 
 	partition, err := strconv.Atoi(pack.HeaderOrDefault("kafka.message.partition", "0"))
 	if nil != err {
-		slf.TagLog(log.Error).Err(err).Msg("Invalid header: kafka.message.partition")
+		return errors.WithMessage(err, "invalid header: kafka.message.partition")
 	}
 
-	if bytes, err := pack.ReadBytes(); nil != err {
-		slf.TagLog(log.Error).Err(err).Msg("Failed to read pack")
-	} else {
-		msg := &sarama.ProducerMessage{
-			Topic:     topic,
-			Key:       sarama.StringEncoder(key),
-			Value:     sarama.ByteEncoder(bytes),
-			Partition: int32(partition),
+	bytes, err := pack.ReadBytes()
+	if nil != err {
+		return errors.WithMessage(err, "read pack")
+	}
+	// 消息没有截止时间时，使用 send_timeout，避免Broker无响应时一直阻塞
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, slf.sendTimeout)
+		defer cancel()
+	}
+	result := make(chan error, 1)
+	msg := &sarama.ProducerMessage{
+		Topic:     topic,
+		Key:       sarama.StringEncoder(key),
+		Value:     sarama.ByteEncoder(bytes),
+		Partition: int32(partition),
+		Metadata:  result,
+	}
+	select {
+	case producer.Input() <- msg:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-slf.collected:
+		return errors.New("producer is closed")
+	}
+	// 等待此消息的发送结果
+	select {
+	case err := <-result:
+		if nil != err {
+			return errors.WithMessage(err, "send message to broker")
 		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-slf.collected:
+		// Producer关闭时，结果可能已在collect协程结束前写入
 		select {
-		case slf.producer.Input() <- msg:
-			// nop
-		case err := <-slf.producer.Errors():
-			slf.TagLog(log.Error).Err(err).Msg("Failed to send message to broker")
+		case err := <-result:
+			if nil != err {
+				return errors.WithMessage(err, "send message to broker")
+			}
+			return nil
+		default:
+			return errors.New("producer is closed")
 		}
 	}
+}
 
+// 检查Producer状态。最近 health_error_window 时间内发送失败时，报告为不健康。
+func (slf *GoPLKafkaProducerOutput) CheckHealth() error {
+	if _, ok := slf.producer.Load().(sarama.AsyncProducer); !ok {
+		return errors.New("producer is not connected")
+	}
+	if last, ok := slf.lastError.Load().(*producerError); ok && time.Since(last.at) < slf.healthWindow {
+		return errors.WithMessage(last.err, "send message to broker")
+	}
+	return nil
 }
 
 func (slf *GoPLKafkaProducerOutput) Shutdown() {
-	if nil != slf.producer {
-		if err := slf.producer.Close(); nil != err {
-			slf.TagLog(log.Error).Err(err).Msg("Failed to close producer")
-		}
+	if producer, ok := slf.producer.Load().(sarama.AsyncProducer); ok {
+		// 发送结果由collect协程继续读取，直到Producer发送完成剩余的消息
+		producer.AsyncClose()
+		<-slf.collected
 	}
 }
@@ -49,6 +49,7 @@ type DefaultURLMatcher struct {
 	spec  string
 	path  string
 	query url.Values
+	trace bool // 是否输出匹配过程的调试信息
 }
 
 func (slf DefaultURLMatcher) String() string {
@@ -56,7 +57,7 @@ func (slf DefaultURLMatcher) String() string {
 }
 
 func (slf *DefaultURLMatcher) Match(pack *DataFrame) bool {
-	traceEnable := Debugs().RoutingTrace
+	traceEnable := slf.trace
 	// Topic的Path部分，是匹配两个Topic是否匹配的第一标准
 	if slf.path == pack.Topic() {
 		// 然后以Matcher为标准，比较Header参数是否匹配
@@ -87,6 +88,15 @@ func (slf *DefaultURLMatcher) Match(pack *DataFrame) bool {
 }
 
 func NewDefaultURLMatcher(spec string) (Matcher, error) {
+	if matcher, err := newDefaultURLMatcher(spec, false); nil != err {
+		return nil, err
+	} else {
+		return matcher, nil
+	}
+}
+
+// 创建URL匹配器。trace 由所属Pipeline的 [Debug] 配置决定
+func newDefaultURLMatcher(spec string, trace bool) (*DefaultURLMatcher, error) {
 	if parsed, err := url.Parse(spec); nil != err {
 		return nil, errors.WithMessage(err, "Default Matcher only accept http URL spec")
 	} else {
@@ -99,6 +109,7 @@ func NewDefaultURLMatcher(spec string) (Matcher, error) {
 			spec:  spec,
 			query: parsed.Query(),
 			path:  path,
+			trace: trace,
 		}, nil
 	}
 }
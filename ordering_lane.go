@@ -0,0 +1,110 @@
+package gopl
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 按Key顺序处理消息：相同Key的消息由固定的协程按到达顺序处理
+//
+
+const defaultLaneCapacity = 256
+
+// 顺序处理通道。消息按 ordering_key 指定的Header值哈希到固定的通道，
+// 每个通道由一个协程按顺序处理，不同通道之间并行处理。
+type orderingLanes struct {
+	key    string
+	lanes  []chan func()
+	mu     *sync.RWMutex
+	closed bool // 已停止，不再接收新任务
+	done   chan struct{}
+	wg     *sync.WaitGroup
+}
+
+// 根据配置创建顺序处理通道。未配置 ordering_key 时返回nil。
+func newOrderingLanes(config RouterConfig) *orderingLanes {
+	if "" == config.OrderingKey {
+		return nil
+	}
+	size := config.OrderingLanes
+	if 0 >= size {
+		size = runtime.NumCPU() * 2
+	}
+	lanes := make([]chan func(), size)
+	for i := range lanes {
+		lanes[i] = make(chan func(), defaultLaneCapacity)
+	}
+	return &orderingLanes{
+		key:   config.OrderingKey,
+		lanes: lanes,
+		mu:    new(sync.RWMutex),
+		done:  make(chan struct{}),
+		wg:    new(sync.WaitGroup),
+	}
+}
+
+func (slf *orderingLanes) start() {
+	for _, lane := range slf.lanes {
+		slf.wg.Add(1)
+		go func(lane chan func()) {
+			defer slf.wg.Done()
+			for {
+				select {
+				case task := <-lane:
+					task()
+				case <-slf.done:
+					return
+				}
+			}
+		}(lane)
+	}
+}
+
+// 将消息处理任务放入其Key对应的通道。消息没有Key时，返回false，由协程池处理。
+func (slf *orderingLanes) post(pack *DataFrame, task func()) bool {
+	key, ok := pack.Header(slf.key)
+	if !ok || "" == key {
+		return false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(key))
+	slf.mu.RLock()
+	if slf.closed {
+		slf.mu.RUnlock()
+		// 已停止，任务直接执行并释放消息
+		task()
+		return true
+	}
+	select {
+	case slf.lanes[hash.Sum32()%uint32(len(slf.lanes))] <- task:
+		slf.mu.RUnlock()
+	case <-slf.done:
+		slf.mu.RUnlock()
+		task()
+	}
+	return true
+}
+
+// 停止所有通道的处理协程。通道中剩余的任务按顺序直接执行，以释放消息；停止后投递的任务直接执行。
+func (slf *orderingLanes) close() {
+	close(slf.done)
+	// 等待正在投递的任务完成
+	slf.mu.Lock()
+	slf.closed = true
+	slf.mu.Unlock()
+	slf.wg.Wait()
+	for _, lane := range slf.lanes {
+		for {
+			select {
+			case task := <-lane:
+				task()
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package gopl
+
+import (
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 按device记录消息序号，处理耗时随机
+type testOrderOutput struct {
+	AbcSlot
+	mu   sync.Mutex
+	seqs map[string][]int
+}
+
+func (slf *testOrderOutput) Output(pack *DataFrame) {
+	time.Sleep(time.Duration(rand.Intn(500)) * time.Microsecond)
+	seq, _ := strconv.Atoi(pack.HeaderOrDefault("seq", "0"))
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	device := pack.HeaderOrDefault("device", "")
+	slf.seqs[device] = append(slf.seqs[device], seq)
+}
+
+func TestRouter_OrderingLanes(t *testing.T) {
+	router := newRouter(8)
+	router.lanes = newOrderingLanes(RouterConfig{OrderingKey: "device", OrderingLanes: 4})
+	output := &testOrderOutput{seqs: make(map[string][]int)}
+	output.SetName("TestOrderOutput")
+	router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "TestOrderOutput"))
+	router.buildRouteTable()
+	router.drainTimeout = time.Second * 5
+	router.threads.Start()
+	defer router.threads.Shutdown()
+	router.lanes.start()
+	defer router.lanes.close()
+
+	devices := []string{"A", "B", "C", "D", "E", ""}
+	for seq := 0; seq < 50; seq++ {
+		for _, device := range devices {
+			pack := NewDataFrame()
+			if "" != device {
+				pack.SetHeader("device", device)
+			}
+			pack.SetHeader("seq", strconv.Itoa(seq))
+			router.post(pack)
+		}
+	}
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain all frames, dropped: %d", dropped)
+	}
+	for _, device := range devices[:5] {
+		seqs := output.seqs[device]
+		if 50 != len(seqs) {
+			t.Fatalf("Frames of device %s not match, was: %d", device, len(seqs))
+		}
+		for i, seq := range seqs {
+			if i != seq {
+				t.Fatalf("Frames of device %s out of order: %v", device, seqs)
+			}
+		}
+	}
+	if 50 != len(output.seqs[""]) {
+		t.Fatalf("Frames without key should be handled by pool, was: %d", len(output.seqs[""]))
+	}
+}
+
+func TestOrderingLanes_Close(t *testing.T) {
+	lanes := newOrderingLanes(RouterConfig{OrderingKey: "device", OrderingLanes: 2})
+	seqs := make([]int, 0)
+	pack := NewDataFrame()
+	pack.SetHeader("device", "A")
+	// 处理协程未启动，任务在通道中等待
+	for seq := 0; seq < 3; seq++ {
+		seq := seq
+		lanes.post(pack, func() { seqs = append(seqs, seq) })
+	}
+	lanes.close()
+	if 3 != len(seqs) || 0 != seqs[0] || 2 != seqs[2] {
+		t.Fatalf("Remaining tasks should run in order on close, was: %v", seqs)
+	}
+	lanes.post(pack, func() { seqs = append(seqs, 3) })
+	if 4 != len(seqs) {
+		t.Fatal("Task posted after close should run directly")
+	}
+}
+
+func TestRouter_OrderingRejectsConcurrentOutputs(t *testing.T) {
+	expectPanic := func(name string, setup func(router *GoPipeline)) {
+		defer func() {
+			if nil == recover() {
+				t.Fatalf("%s should be rejected with ordering_key", name)
+			}
+		}()
+		router := newRouter(1)
+		router.lanes = newOrderingLanes(RouterConfig{OrderingKey: "device"})
+		setup(router)
+		router.buildRouteTable()
+	}
+	expectPanic("parallel_outputs", func(router *GoPipeline) {
+		router.routerConfig.ParallelOutputs = true
+	})
+	expectPanic("workers", func(router *GoPipeline) {
+		output := &testOrderOutput{seqs: make(map[string][]int)}
+		config := &ComponentConfig{QueueSize: 10, Workers: 2}
+		router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), config, "TestOrderOutput"))
+	})
+}
@@ -1,7 +1,10 @@
 package gopl
 
 import (
+	"context"
+	"fmt"
 	"github.com/rs/zerolog/log"
+	"runtime"
 )
 
 //
@@ -15,34 +18,139 @@ type Output interface {
 	Output(pack *DataFrame)
 }
 
+// OutputContext Output组件根据实现，是否支持Context和错误返回。
+// 如果实现，Router优先调用此接口处理消息；Output接口仍需实现，以保持兼容。
+type OutputContext interface {
+	// 处理消息。Context携带消息的截止时间。返回错误时，Router记录此Output的错误计数。
+	OutputContext(ctx context.Context, pack *DataFrame) error
+}
+
 type outputRunner struct {
 	output    Output
+	outputCtx OutputContext
 	matcher   Matcher
 	config    *ComponentConfig
 	configKey string
+	queue     *outputQueue // 独立消息队列，未配置时为nil，由Router协程直接处理
+	counter   *ComponentCounter
+	retry     *retryPolicy    // 重试策略，未配置时为nil
+	breaker   *circuitBreaker // 熔断器，未配置时为nil
+	limiter   *rateLimiter    // 限流器，未配置时为nil
+	batch     *outputBatch    // 批量处理，Output未实现BatchOutput时为nil
+	spool     *outputSpool    // 磁盘暂存，未配置时为nil
+
+	*componentState
 }
 
 func newOutputRunner(output Output, matcher Matcher, config *ComponentConfig, configKey string) *outputRunner {
-	return &outputRunner{
+	outputCtx, _ := output.(OutputContext)
+	runner := &outputRunner{
 		output:    output,
+		outputCtx: outputCtx,
 		matcher:   matcher,
 		config:    config,
 		configKey: configKey,
+		counter:   newComponentCounter(configKey),
+		retry:     newRetryPolicy(config.Retry),
+		limiter:   newRateLimiter(config.RateLimit),
+		spool:     newOutputSpool(configKey, config.Spool),
+
+		componentState: newComponentState(),
+	}
+	runner.breaker = newCircuitBreaker(configKey, config.CircuitBreaker, runner.counter)
+	if _, ok := output.(BatchOutput); ok {
+		runner.batch = newOutputBatch(configKey, config)
+	}
+	// 批次处理失败时整体暂存或者投递死信，不支持按消息重试
+	if nil != runner.retry && nil != runner.batch {
+		log.Panic().Msgf("Output: <%s> retry is not supported by BatchOutput", configKey)
 	}
+	if 0 < config.QueueSize {
+		runner.queue = newOutputQueue(configKey, config.QueueSize, config.Workers, config.Overflow)
+	}
+	// 重试在处理消息的协程中等待，必须由独立队列的协程处理，避免占用Router的协程池
+	if nil != runner.retry && nil == runner.queue {
+		log.Panic().Msgf("Output: <%s> retry requires <queue_size>", configKey)
+	}
+	// 同理，wait策略的限流在处理消息的协程中等待令牌
+	if nil != runner.limiter && runner.limiter.wait && nil == runner.queue {
+		log.Panic().Msgf("Output: <%s> rate_limit policy <wait> requires <queue_size>", configKey)
+	}
+	return runner
 }
 
-func (slf *outputRunner) init() {
+func (slf *outputRunner) init(pipeline *GoPipeline) {
 	pluginName := slf.configKey
 	slf.output.SetName(pluginName)
+	if need, ok := slf.output.(NeedPipeline); ok {
+		need.SetPipeline(pipeline)
+	}
 
-	log.Info().Msgf("Init Output: <%s>, matcher: <%T>", pluginName, slf.matcher)
+	if nil != slf.queue {
+		log.Info().Msgf("Init Output: <%s>, matcher: <%T>, queue: %d, workers: %d, overflow: %s",
+			pluginName, slf.matcher, slf.config.QueueSize, slf.queue.workers, slf.queue.overflow)
+	} else {
+		log.Info().Msgf("Init Output: <%s>, matcher: <%T>", pluginName, slf.matcher)
+	}
+	if nil != slf.batch {
+		log.Info().Msgf("Init Output: <%s>, batch size: %d, bytes: %d, linger: %s",
+			pluginName, slf.batch.size, slf.batch.bytes, slf.batch.linger)
+	}
+	if nil != slf.spool {
+		log.Info().Msgf("Init Output: <%s>, spool dir: %s, max bytes: %d, replay rate: %v",
+			pluginName, slf.spool.config.Dir, slf.spool.config.MaxBytes, slf.spool.config.ReplayRate)
+	}
 	go slf.output.Init(slf.config.InitArgs)
 }
 
-func (slf *outputRunner) runOutput(pack *DataFrame) {
-	slf.output.Output(pack)
+func (slf *outputRunner) runOutput(pack *DataFrame) error {
+	if nil != slf.outputCtx {
+		err := slf.outputCtx.OutputContext(pack.Context(), pack)
+		if nil != err && nil != slf.retry {
+			err = slf.retryOutput(pack, err)
+		}
+		if nil != err {
+			slf.counter.increaseErrors()
+			return err
+		}
+	} else {
+		slf.output.Output(pack)
+	}
+	slf.counter.increaseHandled()
+	return nil
+}
+
+// 批量处理消息。批次中每个消息分别计数。
+func (slf *outputRunner) runOutputBatch(packs []*DataFrame) error {
+	if err := slf.output.(BatchOutput).OutputBatch(packs); nil != err {
+		for range packs {
+			slf.counter.increaseErrors()
+		}
+		return err
+	}
+	for range packs {
+		slf.counter.increaseHandled()
+	}
+	return nil
+}
+
+// 将消息写入磁盘暂存，返回是否已暂存
+func (slf *outputRunner) spoolFrame(pack *DataFrame) bool {
+	if nil == slf.spool || !slf.spool.store(pack) {
+		return false
+	}
+	slf.counter.increaseSpooled()
+	return true
 }
 
 func (slf *outputRunner) checkAccept(pack *DataFrame) bool {
 	return slf.matcher.Match(pack)
 }
+
+// 输出Output处理消息时发生的panic及调用栈，返回用于标记消息处理失败的错误
+func outputPanicError(name string, r interface{}) error {
+	stackBuf := make([]byte, 1024*4)
+	stackBuf = stackBuf[:runtime.Stack(stackBuf, false)]
+	withTag(log.Error).Str("stack", string(stackBuf)).Msgf("Output: <%s> PANIC: %v", name, r)
+	return fmt.Errorf("panic in output: %v", r)
+}
@@ -0,0 +1,112 @@
+package gopl
+
+import (
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Output批量处理消息
+//
+
+const (
+	defaultBatchSize   = 100
+	defaultBatchLinger = time.Millisecond * 100
+)
+
+// BatchOutput Output组件根据实现，是否支持批量处理消息。
+// 如果实现，Router将消息累积为批次后调用此接口；Output接口仍需实现，以保持兼容。
+type BatchOutput interface {
+	// 批量处理消息。消息对象在此函数返回后被释放，不可保留引用。
+	// 返回错误时，批次中所有消息均视为处理失败。
+	OutputBatch(packs []*DataFrame) error
+}
+
+// outputBatch 累积消息，在达到 batch_size、batch_bytes 或者 linger 时间时，将批次交给处理函数。
+// 批次中的消息保持引用，直到批次处理完成后才释放。
+type outputBatch struct {
+	name   string
+	size   int
+	bytes  int64
+	linger time.Duration
+
+	handler func(packs []*DataFrame) // 处理一个批次的消息
+
+	mu      *sync.Mutex
+	packs   []*DataFrame
+	pending int64       // 当前批次的消息字节数
+	timer   *time.Timer // 当前批次的linger定时器
+	flushMu *sync.Mutex // 保证批次按顺序逐个处理
+}
+
+func newOutputBatch(name string, config *ComponentConfig) *outputBatch {
+	size := config.BatchSize
+	if 0 >= size {
+		size = defaultBatchSize
+	}
+	return &outputBatch{
+		name:    name,
+		size:    size,
+		bytes:   config.BatchBytes,
+		linger:  DurationOrDefault(config.Linger, defaultBatchLinger),
+		mu:      new(sync.Mutex),
+		packs:   make([]*DataFrame, 0, size),
+		flushMu: new(sync.Mutex),
+	}
+}
+
+func (slf *outputBatch) start(handler func(packs []*DataFrame)) {
+	slf.handler = handler
+}
+
+// 将消息加入当前批次。批次已满时，在当前协程中处理批次。
+func (slf *outputBatch) add(pack *DataFrame) {
+	retainDataFrame(pack)
+	slf.mu.Lock()
+	slf.packs = append(slf.packs, pack)
+	if 0 < pack.BodyLength() {
+		slf.pending += pack.BodyLength()
+	}
+	full := len(slf.packs) >= slf.size || (0 < slf.bytes && slf.pending >= slf.bytes)
+	if !full && 1 == len(slf.packs) {
+		slf.timer = time.AfterFunc(slf.linger, slf.flush)
+	}
+	slf.mu.Unlock()
+	if full {
+		slf.flush()
+	}
+}
+
+// 处理当前批次的消息，并释放消息
+func (slf *outputBatch) flush() {
+	slf.flushMu.Lock()
+	defer slf.flushMu.Unlock()
+	slf.mu.Lock()
+	packs := slf.packs
+	slf.packs = make([]*DataFrame, 0, slf.size)
+	slf.pending = 0
+	if nil != slf.timer {
+		slf.timer.Stop()
+		slf.timer = nil
+	}
+	slf.mu.Unlock()
+	if 0 == len(packs) {
+		return
+	}
+	defer func() {
+		for _, pack := range packs {
+			releaseDataFrame(pack)
+		}
+	}()
+	// 批次可能在linger定时器协程中处理，发生panic时批次中所有消息均标记为处理失败
+	defer func() {
+		if r := recover(); nil != r {
+			err := outputPanicError(slf.name, r)
+			for _, pack := range packs {
+				pack.fail(err)
+			}
+		}
+	}()
+	slf.handler(packs)
+}
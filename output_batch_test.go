@@ -0,0 +1,134 @@
+package gopl
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 记录每个批次的消息Body
+type testBatchOutput struct {
+	AbcSlot
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (slf *testBatchOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testBatchOutput) OutputBatch(packs []*DataFrame) error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	bodies := make([]string, 0, len(packs))
+	for _, pack := range packs {
+		body, _ := pack.ReadBytes()
+		bodies = append(bodies, string(body))
+	}
+	slf.batches = append(slf.batches, bodies)
+	return nil
+}
+
+func (slf *testBatchOutput) snapshot() [][]string {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	return append([][]string{}, slf.batches...)
+}
+
+func TestRouter_BatchOutput(t *testing.T) {
+	router := newRouter(1)
+	output := new(testBatchOutput)
+	output.SetName("TestBatchOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{BatchSize: 3, Linger: "30ms"}, "TestBatchOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+
+	for _, body := range []string{"a", "b", "c", "d", "e", "f", "g"} {
+		pack := NewDataFrame()
+		pack.SetBody(strings.NewReader(body))
+		// deliver0 处理完成后释放消息，批次仍持有消息引用
+		router.deliver0(pack)
+	}
+	if batches := output.snapshot(); 2 != len(batches) ||
+		"abc" != strings.Join(batches[0], "") || "def" != strings.Join(batches[1], "") {
+		t.Fatalf("Full batches not match, was: %v", batches)
+	}
+
+	// 批次未满时，等待linger后处理
+	time.Sleep(time.Millisecond * 60)
+	if batches := output.snapshot(); 3 != len(batches) || "g" != strings.Join(batches[2], "") {
+		t.Fatalf("Linger batch not match, was: %v", batches)
+	}
+	if 7 != runner.counter.Handled() {
+		t.Fatalf("Handled counter not match, was: %d", runner.counter.Handled())
+	}
+}
+
+func TestRouter_BatchOutputFlushOnClose(t *testing.T) {
+	router := newRouter(1)
+	output := new(testBatchOutput)
+	output.SetName("TestBatchOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{BatchSize: 10, BatchBytes: 4, Linger: "1h"}, "TestBatchOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+
+	for _, body := range []string{"aa", "bb", "c"} {
+		pack := NewDataFrame()
+		pack.SetBody(strings.NewReader(body))
+		router.deliver0(pack)
+	}
+	if batches := output.snapshot(); 1 != len(batches) || "aabb" != strings.Join(batches[0], "") {
+		t.Fatalf("Batch by bytes not match, was: %v", batches)
+	}
+	closeRunner(runner)
+	if batches := output.snapshot(); 2 != len(batches) || "c" != strings.Join(batches[1], "") {
+		t.Fatalf("Batch should be flushed on close, was: %v", batches)
+	}
+}
+
+// 处理批次时panic
+type testPanicBatchOutput struct {
+	AbcSlot
+}
+
+func (slf *testPanicBatchOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testPanicBatchOutput) OutputBatch(packs []*DataFrame) error {
+	panic("batch crashed")
+}
+
+func TestRouter_BatchOutputRecoverPanic(t *testing.T) {
+	router := newRouter(1)
+	output := new(testPanicBatchOutput)
+	output.SetName("TestPanicBatchOutput")
+	runner := newOutputRunner(output, new(AnyMatcher), &ComponentConfig{BatchSize: 10, Linger: "10ms"}, "TestPanicBatchOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+
+	recorder := new(testAckRecorder)
+	for _, body := range []string{"a", "b"} {
+		pack := NewDataFrame()
+		pack.SetBody(strings.NewReader(body))
+		pack.SetAckHandler(recorder.handler)
+		router.deliver0(pack)
+	}
+	// 批次在linger定时器协程中处理，panic不应导致进程退出
+	time.Sleep(time.Millisecond * 50)
+	acks := recorder.results()
+	if 2 != len(acks) {
+		t.Fatalf("Every frame in batch should be acked, was: %v", acks)
+	}
+	for _, err := range acks {
+		if nil == err {
+			t.Fatalf("Panicked batch should fail frames, was: %v", acks)
+		}
+	}
+}
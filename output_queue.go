@@ -0,0 +1,143 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Output独立消息队列
+//
+
+// Output消息队列满时的处理策略
+const (
+	OverflowBlock   = "block"    // 阻塞等待队列空闲
+	OverflowDropNew = "drop_new" // 丢弃新消息
+	OverflowDropOld = "drop_old" // 丢弃队列中最旧的消息
+)
+
+// outputQueue 为Output提供有界消息队列和独立的处理协程。
+// Router只负责将消息放入队列，Output阻塞时只会占满自己的队列，不会消耗Router的协程池。
+type outputQueue struct {
+	name     string
+	overflow string
+	workers  int
+	frames   chan *DataFrame
+	counter  *QueueCounter
+	verbose  bool // 输出丢弃消息的日志，来自所属Pipeline的Debug配置
+
+	handler func(pack *DataFrame) // 处理队列中的消息，消息由队列释放
+	dropper func(pack *DataFrame) // 处理被丢弃的消息
+
+	mu     *sync.RWMutex
+	closed bool
+	wg     *sync.WaitGroup
+}
+
+func newOutputQueue(name string, size int, workers int, overflow string) *outputQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	switch overflow {
+	case OverflowBlock, OverflowDropNew, OverflowDropOld:
+	case "":
+		overflow = OverflowBlock
+	default:
+		log.Panic().Msgf("Invalid overflow policy: <%s>, for Output: <%s>", overflow, name)
+	}
+	frames := make(chan *DataFrame, size)
+	return &outputQueue{
+		name:     name,
+		overflow: overflow,
+		workers:  workers,
+		frames:   frames,
+		counter: newQueueCounter(name, size, func() int {
+			return len(frames)
+		}),
+		mu: new(sync.RWMutex),
+		wg: new(sync.WaitGroup),
+	}
+}
+
+// 启动处理协程
+func (slf *outputQueue) start(handler func(pack *DataFrame), dropper func(pack *DataFrame)) {
+	slf.handler = handler
+	slf.dropper = dropper
+	for i := 0; i < slf.workers; i++ {
+		slf.wg.Add(1)
+		go func() {
+			defer slf.wg.Done()
+			for pack := range slf.frames {
+				slf.handle(pack)
+			}
+		}()
+	}
+}
+
+// 处理队列中的消息，处理完成后释放消息。Output发生panic时，消息标记为处理失败，处理协程继续运行。
+func (slf *outputQueue) handle(pack *DataFrame) {
+	defer releaseDataFrame(pack)
+	defer func() {
+		if r := recover(); nil != r {
+			pack.fail(outputPanicError(slf.name, r))
+		}
+	}()
+	slf.handler(pack)
+}
+
+// 将消息放入队列。队列已满时，根据Overflow策略处理。
+func (slf *outputQueue) offer(pack *DataFrame) {
+	slf.mu.RLock()
+	defer slf.mu.RUnlock()
+
+	if slf.closed {
+		slf.drop(pack)
+		return
+	}
+
+	switch slf.overflow {
+	case OverflowDropNew:
+		select {
+		case slf.frames <- pack:
+		default:
+			slf.drop(pack)
+		}
+
+	case OverflowDropOld:
+		for {
+			select {
+			case slf.frames <- pack:
+				return
+			default:
+				select {
+				case old := <-slf.frames:
+					slf.drop(old)
+				default:
+				}
+			}
+		}
+
+	default:
+		slf.frames <- pack
+	}
+}
+
+func (slf *outputQueue) drop(pack *DataFrame) {
+	slf.counter.increaseDropped()
+	if slf.verbose {
+		withTag(log.Debug).Msgf("Output: <%s> queue FULL, DROPPED, sender: %s", slf.name, pack.Sender())
+	}
+	slf.dropper(pack)
+}
+
+// 关闭队列，并等待队列中的消息处理完成
+func (slf *outputQueue) close() {
+	slf.mu.Lock()
+	if !slf.closed {
+		slf.closed = true
+		close(slf.frames)
+	}
+	slf.mu.Unlock()
+	slf.wg.Wait()
+}
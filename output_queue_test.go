@@ -0,0 +1,93 @@
+package gopl
+
+import (
+	"sync"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func TestOutputQueue_DropNew(t *testing.T) {
+	queue := newOutputQueue("TestDropNew", 2, 1, OverflowDropNew)
+	dropped := make([]*DataFrame, 0)
+	queue.dropper = func(pack *DataFrame) {
+		dropped = append(dropped, pack)
+	}
+	// 未启动处理协程，第3个消息开始被丢弃
+	frames := []*DataFrame{NewDataFrame(), NewDataFrame(), NewDataFrame()}
+	for _, f := range frames {
+		queue.offer(f)
+	}
+	if 1 != len(dropped) || frames[2] != dropped[0] {
+		t.Fatalf("Drop new frame failed, dropped: %d", len(dropped))
+	}
+	if 2 != queue.counter.Depth() || 1 != queue.counter.Dropped() {
+		t.Fatalf("Counter not match, depth: %d, dropped: %d", queue.counter.Depth(), queue.counter.Dropped())
+	}
+}
+
+func TestOutputQueue_DropOld(t *testing.T) {
+	queue := newOutputQueue("TestDropOld", 2, 1, OverflowDropOld)
+	dropped := make([]*DataFrame, 0)
+	queue.dropper = func(pack *DataFrame) {
+		dropped = append(dropped, pack)
+	}
+	frames := []*DataFrame{NewDataFrame(), NewDataFrame(), NewDataFrame()}
+	for _, f := range frames {
+		queue.offer(f)
+	}
+	if 1 != len(dropped) || frames[0] != dropped[0] {
+		t.Fatalf("Drop old frame failed, dropped: %d", len(dropped))
+	}
+	if frames[1] != <-queue.frames || frames[2] != <-queue.frames {
+		t.Fatal("Queue order not match")
+	}
+}
+
+func TestOutputQueue_Close(t *testing.T) {
+	queue := newOutputQueue("TestClose", 8, 2, OverflowBlock)
+	mu := new(sync.Mutex)
+	handled := 0
+	queue.start(func(pack *DataFrame) {
+		mu.Lock()
+		handled++
+		mu.Unlock()
+	}, func(pack *DataFrame) {
+		t.Fatal("Should not drop")
+	})
+	for i := 0; i < 100; i++ {
+		queue.offer(NewDataFrame())
+	}
+	queue.close()
+	if 100 != handled {
+		t.Fatalf("Handled not match, was: %d", handled)
+	}
+}
+
+func TestOutputQueue_RecoverPanic(t *testing.T) {
+	queue := newOutputQueue("TestPanic", 8, 1, OverflowBlock)
+	handled := 0
+	queue.start(func(pack *DataFrame) {
+		if "true" == pack.HeaderOrDefault("panic", "") {
+			panic("output crashed")
+		}
+		handled++
+	}, func(pack *DataFrame) {
+		t.Fatal("Should not drop")
+	})
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetHeader("panic", "true")
+	pack.SetAckHandler(recorder.handler)
+	queue.offer(pack)
+	queue.offer(NewDataFrame())
+	queue.close()
+	if 1 != handled {
+		t.Fatalf("Worker should keep running after panic, handled: %d", handled)
+	}
+	if acks := recorder.results(); 1 != len(acks) || nil == acks[0] {
+		t.Fatalf("Panicked frame should be failed, was: %v", acks)
+	}
+}
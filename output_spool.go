@@ -0,0 +1,183 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline/spool"
+	"io"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Output磁盘暂存：下游不可用时，处理失败、熔断或者队列溢出的消息写入磁盘，下游恢复后按顺序重新发送
+//
+
+const defaultSpoolRetryInterval = time.Second
+
+// Output磁盘暂存配置
+type SpoolConfig struct {
+	Dir           string  `toml:"spool_dir"`      // 暂存目录，每个Output使用独立的目录
+	MaxBytes      int64   `toml:"max_bytes"`      // 暂存文件最大字节数，超过时消息按处理失败处理。0表示不限制
+	ReplayRate    float64 `toml:"replay_rate"`    // 每秒重新发送的最多消息数量，0表示不限制
+	RetryInterval string  `toml:"retry_interval"` // 重新发送失败时，再次尝试的间隔时间，默认1s
+	MaxAttempts   int     `toml:"max_attempts"`   // 每个消息最多发送次数，超过时放弃此消息。0表示不限制
+}
+
+type outputSpool struct {
+	name     string
+	config   *SpoolConfig
+	interval time.Duration // 重新发送的最小间隔
+	retry    time.Duration
+
+	mu    *sync.RWMutex
+	queue *spool.Queue // 在Output启动时打开，停止时关闭
+	stop  chan struct{}
+	wg    *sync.WaitGroup
+}
+
+// 根据配置创建磁盘暂存。未配置，或者 spool_dir 为空时返回nil。
+func newOutputSpool(name string, config *SpoolConfig) *outputSpool {
+	if nil == config || "" == config.Dir {
+		return nil
+	}
+	out := &outputSpool{
+		name:   name,
+		config: config,
+		retry:  DurationOrDefault(config.RetryInterval, defaultSpoolRetryInterval),
+		mu:     new(sync.RWMutex),
+		wg:     new(sync.WaitGroup),
+	}
+	if 0 < config.ReplayRate {
+		out.interval = time.Duration(float64(time.Second) / config.ReplayRate)
+	}
+	return out
+}
+
+// 打开暂存目录，并启动重新发送协程。上次停止时暂存的消息继续发送。
+// 消息被放弃时（不可重试的错误，或者超过 max_attempts），调用 discard 处理此消息。
+func (slf *outputSpool) start(handler func(pack *DataFrame) error, discard func(pack *DataFrame, err error)) {
+	queue, err := spool.Open(slf.config.Dir, spool.Options{MaxBytes: slf.config.MaxBytes})
+	if nil != err {
+		withTag(log.Error).Err(err).Msgf("Output: <%s> open spool dir FAILED: %s", slf.name, slf.config.Dir)
+		return
+	}
+	if remains := queue.Len(); 0 < remains {
+		withTag(log.Info).Msgf("Output: <%s> recover spooled frames: %d", slf.name, remains)
+	}
+	slf.mu.Lock()
+	slf.queue = queue
+	slf.stop = make(chan struct{})
+	slf.mu.Unlock()
+	slf.wg.Add(1)
+	go slf.replay(queue, slf.stop, handler, discard)
+}
+
+// 暂存中存在未发送的消息。此时新消息也需要暂存，以保持消息顺序。
+func (slf *outputSpool) pending() bool {
+	slf.mu.RLock()
+	defer slf.mu.RUnlock()
+	return nil != slf.queue && 0 < slf.queue.Len()
+}
+
+// 将消息写入暂存。暂存未打开、已满或者写入失败时返回false，消息由调用方处理。
+func (slf *outputSpool) store(pack *DataFrame) bool {
+	slf.mu.RLock()
+	defer slf.mu.RUnlock()
+	if nil == slf.queue {
+		return false
+	}
+	data, err := encodeDataFrame(pack)
+	if nil == err {
+		_, err = slf.queue.Append(data)
+	}
+	if nil != err {
+		if spool.ErrFull != err {
+			withTag(log.Error).Err(err).Msgf("Output: <%s> spool frame FAILED, sender: %s", slf.name, pack.Sender())
+		}
+		return false
+	}
+	return true
+}
+
+// 按顺序重新发送暂存的消息。发送失败时，间隔 retry_interval 后重试同一个消息；
+// 不可重试的错误，或者发送次数超过 max_attempts 时，放弃此消息并继续发送后续消息。
+func (slf *outputSpool) replay(queue *spool.Queue, stop <-chan struct{}, handler func(pack *DataFrame) error, discard func(pack *DataFrame, err error)) {
+	defer slf.wg.Done()
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-stop:
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+	for {
+		offset, data, err := queue.Next()
+		if io.EOF == err {
+			select {
+			case <-stop:
+				return
+			case <-queue.Signal():
+			}
+			continue
+		}
+		if nil != err {
+			withTag(log.Error).Err(err).Msgf("Output: <%s> read spooled frame FAILED", slf.name)
+			return
+		}
+		for attempts := 0; ; {
+			pack, err := decodeDataFrame(data)
+			if nil != err {
+				withTag(log.Error).Err(err).Msgf("Output: <%s> decode spooled frame FAILED, offset: %d", slf.name, offset)
+				break
+			}
+			// 暂存的消息不再受原截止时间限制
+			pack.clearDeadline()
+			err = handler(pack)
+			if nil == err {
+				releaseDataFrame(pack)
+				break
+			}
+			// 熔断中未发送，不计入发送次数
+			if ErrCircuitOpen != err {
+				attempts++
+			}
+			if isNonRetryable(err) || (0 < slf.config.MaxAttempts && attempts >= slf.config.MaxAttempts) {
+				withTag(log.Error).Err(err).Msgf("Output: <%s> spooled frame DISCARDED, offset: %d, attempts: %d", slf.name, offset, attempts)
+				discard(pack, err)
+				releaseDataFrame(pack)
+				break
+			}
+			releaseDataFrame(pack)
+			if !wait(slf.retry) {
+				return
+			}
+		}
+		if err := queue.Commit(offset); nil != err {
+			withTag(log.Error).Err(err).Msgf("Output: <%s> commit spooled frame FAILED, offset: %d", slf.name, offset)
+		}
+		if 0 < slf.interval && !wait(slf.interval) {
+			return
+		}
+	}
+}
+
+// 停止重新发送，并关闭暂存目录。未发送的消息在下次启动时继续发送。
+func (slf *outputSpool) close() {
+	slf.mu.Lock()
+	queue, stop := slf.queue, slf.stop
+	slf.queue, slf.stop = nil, nil
+	slf.mu.Unlock()
+	if nil == queue {
+		return
+	}
+	close(stop)
+	slf.wg.Wait()
+	if remains := queue.Len(); 0 < remains {
+		withTag(log.Info).Msgf("Output: <%s> spooled frames remains: %d, will replay on next startup", slf.name, remains)
+	}
+	queue.Close()
+}
@@ -0,0 +1,206 @@
+package gopl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 可切换下游状态的Output，记录成功发送的消息Body
+type testDownstreamOutput struct {
+	AbcSlot
+	mu     sync.Mutex
+	down   bool
+	bodies []string
+}
+
+func (slf *testDownstreamOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testDownstreamOutput) OutputContext(ctx context.Context, pack *DataFrame) error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.down {
+		return errors.New("downstream unavailable")
+	}
+	body, _ := pack.ReadBytes()
+	slf.bodies = append(slf.bodies, string(body))
+	return nil
+}
+
+func (slf *testDownstreamOutput) setDown(down bool) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.down = down
+}
+
+func (slf *testDownstreamOutput) received() []string {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	return append([]string{}, slf.bodies...)
+}
+
+func newTestSpoolRouter(dir string, output *testDownstreamOutput) (*GoPipeline, *outputRunner) {
+	router := newRouter(1)
+	output.SetName("SpoolOutput")
+	config := &ComponentConfig{Spool: &SpoolConfig{Dir: dir, RetryInterval: "5ms"}}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "SpoolOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+	return router, runner
+}
+
+func waitReceived(t *testing.T, output *testDownstreamOutput, expected ...string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if len(expected) <= len(output.received()) {
+			break
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	received := output.received()
+	if len(expected) != len(received) {
+		t.Fatalf("Received frames not match, was: %v", received)
+	}
+	for i, body := range expected {
+		if body != received[i] {
+			t.Fatalf("Received frames order not match, was: %v", received)
+		}
+	}
+}
+
+func TestOutputSpool_ReplayInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-output-spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := &testDownstreamOutput{down: true}
+	router, runner := newTestSpoolRouter(dir, output)
+	defer runner.spool.close()
+	recorder := new(testAckRecorder)
+	for _, body := range []string{"a", "b"} {
+		pack := NewDataFrame()
+		pack.SetAckHandler(recorder.handler)
+		pack.SetBody(bytes.NewBufferString(body))
+		router.deliver0(pack)
+	}
+	if 2 != runner.counter.Spooled() {
+		t.Fatalf("Spooled frames not match, was: %d", runner.counter.Spooled())
+	}
+	if acks := recorder.results(); 2 != len(acks) || nil != acks[0] || nil != acks[1] {
+		t.Fatalf("Spooled frames should be acked, was: %v", acks)
+	}
+	output.setDown(false)
+	waitReceived(t, output, "a", "b")
+
+	pack := NewDataFrame()
+	pack.SetBody(bytes.NewBufferString("c"))
+	router.deliver0(pack)
+	waitReceived(t, output, "a", "b", "c")
+	if 2 != runner.counter.Spooled() {
+		t.Fatalf("Should send directly after replayed, spooled: %d", runner.counter.Spooled())
+	}
+}
+
+func TestOutputSpool_Recover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-output-spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := &testDownstreamOutput{down: true}
+	router, runner := newTestSpoolRouter(dir, output)
+	pack := NewDataFrame()
+	pack.SetBody(bytes.NewBufferString("a"))
+	router.deliver0(pack)
+	runner.spool.close()
+
+	// 重新启动后继续发送暂存的消息
+	output = &testDownstreamOutput{}
+	_, runner = newTestSpoolRouter(dir, output)
+	defer runner.spool.close()
+	waitReceived(t, output, "a")
+}
+
+// 按Body返回错误的Output，记录每次发送的消息Body
+type testPoisonOutput struct {
+	AbcSlot
+	mu       sync.Mutex
+	failures map[string]error
+	bodies   []string
+}
+
+func (slf *testPoisonOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testPoisonOutput) OutputContext(ctx context.Context, pack *DataFrame) error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	body, _ := pack.ReadBytes()
+	slf.bodies = append(slf.bodies, string(body))
+	return slf.failures[string(body)]
+}
+
+func (slf *testPoisonOutput) count(body string) int {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	n := 0
+	for _, b := range slf.bodies {
+		if body == b {
+			n++
+		}
+	}
+	return n
+}
+
+func TestOutputSpool_DiscardPoisonFrames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-output-spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	output := &testPoisonOutput{failures: map[string]error{
+		"bad":    NonRetryable(errors.New("invalid payload")),
+		"flaky":  errors.New("downstream unavailable"),
+		"normal": nil,
+	}}
+	output.SetName("PoisonOutput")
+	router := newRouter(1)
+	config := &ComponentConfig{Spool: &SpoolConfig{Dir: dir, RetryInterval: "1ms", MaxAttempts: 3}}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "PoisonOutput")
+	router.outputRunners.PushBack(runner)
+	router.buildRouteTable()
+	router.startOutput(runner)
+	defer runner.spool.close()
+	for _, body := range []string{"bad", "flaky", "normal"} {
+		pack := NewDataFrame()
+		pack.SetBody(bytes.NewBufferString(body))
+		if !runner.spool.store(pack) {
+			t.Fatalf("Should store frame: %s", body)
+		}
+		releaseDataFrame(pack)
+	}
+
+	// 放弃的消息不阻塞后续消息
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) && 0 == output.count("normal") {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if 1 != output.count("bad") || 3 != output.count("flaky") || 1 != output.count("normal") {
+		t.Fatalf("Attempts not match, was: %v", output.bodies)
+	}
+}
@@ -0,0 +1,124 @@
+package gopl
+
+import (
+	"github.com/parkingwang/go-conf"
+	"github.com/rs/zerolog/log"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 命名管道：显式声明Input集合、有序的Filter列表和Output集合
+//
+
+const pipelineConfigKey = "Pipeline"
+
+// 命名管道配置选项。它对应着配置文件的 [[Pipeline]] 配置项。
+type PipelineConfig struct {
+	Name     string   `toml:"name"`     // 管道名称
+	Disabled bool     `toml:"disabled"` // 是否禁用此管道
+	Inputs   []string `toml:"inputs"`   // 管道接收消息的Input配置名列表
+	Filters  []string `toml:"filters"`  // 管道按顺序处理消息的Filter配置名列表
+	Outputs  []string `toml:"outputs"`  // 管道输出消息的Output配置名列表
+}
+
+type pipelineRoute struct {
+	name    string
+	inputs  map[string]bool
+	filters []*filterRunner
+	outputs []*outputRunner
+}
+
+// 判断消息是否由管道声明的Input发送
+func (slf *pipelineRoute) accept(pack *DataFrame) bool {
+	return slf.inputs[pack.Sender()]
+}
+
+// 解析 [[Pipeline]] 配置，并根据组件配置名查找组件。
+func (slf *GoPipeline) setupPipelines() {
+	slf.pipelines = nil
+	raw, ok := slf.rootConfig[pipelineConfigKey]
+	if !ok {
+		return
+	}
+	configs := make([]PipelineConfig, 0)
+	if err := conf.Map2Struct(raw, &configs); nil != err {
+		withTag(log.Panic).Err(err).Msg("Failed to decode [[Pipeline]] config")
+	}
+
+	inputs := make(map[string]bool)
+	for ele := slf.inputRunners.Front(); ele != nil; ele = ele.Next() {
+		inputs[ele.Value.(*inputRunner).configKey] = true
+	}
+	filters := make(map[string]*filterRunner)
+	for ele := slf.filterRunners.Front(); ele != nil; ele = ele.Next() {
+		fr := ele.Value.(*filterRunner)
+		filters[fr.configKey] = fr
+	}
+	outputs := make(map[string]*outputRunner)
+	for ele := slf.outputRunners.Front(); ele != nil; ele = ele.Next() {
+		or := ele.Value.(*outputRunner)
+		outputs[or.configKey] = or
+	}
+
+	used := make(map[string]bool)
+	for _, config := range configs {
+		if "" == config.Name {
+			withTag(log.Panic).Msg("Pipeline <name> is required")
+		}
+		if config.Disabled {
+			withTag(log.Warn).Msgf("Pipeline[%s] define in .toml, but is set to <DISABLED>", config.Name)
+			continue
+		}
+		route := &pipelineRoute{
+			name:    config.Name,
+			inputs:  make(map[string]bool),
+			filters: make([]*filterRunner, 0, len(config.Filters)),
+			outputs: make([]*outputRunner, 0, len(config.Outputs)),
+		}
+		for _, key := range config.Inputs {
+			if !inputs[key] {
+				withTag(log.Panic).Msgf("Input: <%s> NOT WORKING, for Pipeline: <%s>", key, config.Name)
+			}
+			route.inputs[key] = true
+			used[key] = true
+		}
+		for _, key := range config.Filters {
+			fr, ok := filters[key]
+			if !ok {
+				withTag(log.Panic).Msgf("Filter: <%s> NOT WORKING, for Pipeline: <%s>", key, config.Name)
+			}
+			route.filters = append(route.filters, fr)
+			used[key] = true
+		}
+		for _, key := range config.Outputs {
+			or, ok := outputs[key]
+			if !ok {
+				withTag(log.Panic).Msgf("Output: <%s> NOT WORKING, for Pipeline: <%s>", key, config.Name)
+			}
+			route.outputs = append(route.outputs, or)
+			used[key] = true
+		}
+		slf.pipelines = append(slf.pipelines, route)
+		withTag(log.Info).Msgf("Working Pipeline: <%s>, inputs: %s, filters: %s, outputs: %s",
+			config.Name, config.Inputs, config.Filters, config.Outputs)
+	}
+
+	// 启用管道模式后，未被任何管道引用的组件不会处理消息
+	if 0 < len(slf.pipelines) {
+		for key := range inputs {
+			if !used[key] {
+				withTag(log.Warn).Msgf("Input: <%s> NOT used by any Pipeline", key)
+			}
+		}
+		for key := range filters {
+			if !used[key] {
+				withTag(log.Warn).Msgf("Filter: <%s> NOT used by any Pipeline", key)
+			}
+		}
+		for key := range outputs {
+			if !used[key] {
+				withTag(log.Warn).Msgf("Output: <%s> NOT used by any Pipeline", key)
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 按优先级处理消息：不同优先级的消息进入独立的队列，按权重轮流派发到协程池
+//
+
+// 消息优先级Header。Input配置的 priority 在消息未设置此Header时注入。
+const HeaderPriority = "Priority"
+
+// 消息优先级
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal" // 未设置或者无法识别的优先级，按normal处理
+	PriorityLow    = "low"
+)
+
+var defaultPriorityWeights = []int{8, 4, 1}
+
+// 优先级队列。协程池繁忙时，消息在各优先级队列中等待；
+// 调度协程每轮从high/normal/low队列分别取出最多 weight 个任务派发到协程池，高优先级的消息等待更短的时间。
+type priorityLanes struct {
+	weights  []int
+	lanes    []chan func() // 按 high/normal/low 顺序
+	dispatch func(task func())
+	done     chan struct{}
+	wg       *sync.WaitGroup
+}
+
+// 根据配置创建优先级队列。未配置 priority_weights 时返回nil。
+func newPriorityLanes(config RouterConfig) *priorityLanes {
+	if 0 == len(config.PriorityWeights) {
+		return nil
+	}
+	if len(defaultPriorityWeights) != len(config.PriorityWeights) {
+		withTag(log.Panic).Msgf("Invalid priority_weights: %v, require [high, normal, low]", config.PriorityWeights)
+	}
+	weights := make([]int, len(config.PriorityWeights))
+	for i, w := range config.PriorityWeights {
+		weights[i] = w
+		if 0 >= w {
+			weights[i] = defaultPriorityWeights[i]
+		}
+	}
+	size := config.PriorityQueueSize
+	if 0 >= size {
+		size = defaultMaxPending
+	}
+	lanes := make([]chan func(), len(weights))
+	for i := range lanes {
+		lanes[i] = make(chan func(), size)
+	}
+	return &priorityLanes{
+		weights: weights,
+		lanes:   lanes,
+		done:    make(chan struct{}),
+		wg:      new(sync.WaitGroup),
+	}
+}
+
+// 启动调度协程，按权重将任务交给dispatch派发
+func (slf *priorityLanes) start(dispatch func(task func())) {
+	slf.dispatch = dispatch
+	slf.wg.Add(1)
+	go slf.schedule()
+}
+
+func (slf *priorityLanes) schedule() {
+	defer slf.wg.Done()
+	for {
+		if 0 < slf.round() {
+			continue
+		}
+		// 所有队列为空，等待新任务
+		select {
+		case task := <-slf.lanes[0]:
+			slf.dispatch(task)
+		case task := <-slf.lanes[1]:
+			slf.dispatch(task)
+		case task := <-slf.lanes[2]:
+			slf.dispatch(task)
+		case <-slf.done:
+			return
+		}
+	}
+}
+
+// 一轮调度：从每个队列取出最多 weight 个任务。返回派发的任务数量。
+func (slf *priorityLanes) round() int {
+	dispatched := 0
+	for i, lane := range slf.lanes {
+	weighted:
+		for n := 0; n < slf.weights[i]; n++ {
+			select {
+			case task := <-lane:
+				slf.dispatch(task)
+				dispatched++
+			default:
+				break weighted
+			}
+		}
+	}
+	return dispatched
+}
+
+// 将消息处理任务放入其优先级对应的队列。队列已满时阻塞。
+func (slf *priorityLanes) post(pack *DataFrame, task func()) {
+	select {
+	case <-slf.done:
+		task()
+		return
+	default:
+	}
+	select {
+	case slf.lanes[priorityIndex(pack)] <- task:
+	case <-slf.done:
+		// 已停止，任务直接执行并释放消息
+		task()
+	}
+}
+
+func priorityIndex(pack *DataFrame) int {
+	switch pack.HeaderOrDefault(HeaderPriority, PriorityNormal) {
+	case PriorityHigh:
+		return 0
+	case PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// 停止调度协程。队列中剩余的任务直接执行，以释放消息。
+func (slf *priorityLanes) close() {
+	close(slf.done)
+	slf.wg.Wait()
+	for _, lane := range slf.lanes {
+		for {
+			select {
+			case task := <-lane:
+				task()
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package gopl
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 按处理顺序记录消息优先级。第一个消息阻塞，直到release被关闭
+type testPriorityOutput struct {
+	AbcSlot
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	order   []string
+}
+
+func (slf *testPriorityOutput) Output(pack *DataFrame) {
+	slf.once.Do(func() {
+		<-slf.release
+	})
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.order = append(slf.order, pack.HeaderOrDefault(HeaderPriority, PriorityNormal))
+}
+
+func TestRouter_PriorityLanes(t *testing.T) {
+	router := newRouter(1)
+	router.priority = newPriorityLanes(RouterConfig{PriorityWeights: []int{8, 4, 1}})
+	output := &testPriorityOutput{release: make(chan struct{})}
+	output.SetName("TestPriorityOutput")
+	router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "TestPriorityOutput"))
+	router.buildRouteTable()
+	router.drainTimeout = time.Second * 5
+	router.threads.Start()
+	defer router.threads.Shutdown()
+	router.priority.start(router.threads.Post)
+	defer router.priority.close()
+
+	// 协程池被阻塞时，低优先级消息先到达
+	for i := 0; i < 20; i++ {
+		pack := NewDataFrame()
+		pack.SetHeader(HeaderPriority, PriorityLow)
+		router.post(pack)
+	}
+	for i := 0; i < 5; i++ {
+		pack := NewDataFrame()
+		pack.SetHeader(HeaderPriority, PriorityHigh)
+		router.post(pack)
+	}
+	close(output.release)
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain all frames, dropped: %d", dropped)
+	}
+	if 25 != len(output.order) {
+		t.Fatalf("Handled frames not match, was: %d", len(output.order))
+	}
+	// 已派发到协程池的少量低优先级消息之后，高优先级消息优先处理
+	last := 0
+	for i, p := range output.order {
+		if PriorityHigh == p {
+			last = i
+		}
+	}
+	if last >= 10 {
+		t.Fatalf("High priority frames should be handled first, was: %v", output.order)
+	}
+}
+
+func TestPriorityIndex(t *testing.T) {
+	cases := map[string]int{PriorityHigh: 0, PriorityNormal: 1, PriorityLow: 2, "unknown": 1}
+	for priority, expected := range cases {
+		pack := NewDataFrame()
+		pack.SetHeader(HeaderPriority, priority)
+		if index := priorityIndex(pack); expected != index {
+			t.Fatalf("Priority index not match, priority: %s, was: %d", priority, index)
+		}
+	}
+	if 1 != priorityIndex(NewDataFrame()) {
+		t.Fatal("Default priority should be normal")
+	}
+}
@@ -0,0 +1,146 @@
+package gopl
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 令牌桶限流：限制Input投递和Output处理消息的速率
+//
+
+// 限流策略
+const (
+	RateLimitWait = "wait" // 等待令牌。默认策略
+	RateLimitDrop = "drop" // 没有令牌时丢弃消息
+)
+
+const defaultRateLimitMaxKeys = 10000
+
+var (
+	// Input被限流，消息被丢弃时返回的错误
+	ErrDeliverThrottled = errors.New("deliver rejected: input is throttled")
+	// Output被限流，消息被丢弃时确认回调收到的错误
+	ErrOutputThrottled = errors.New("output rejected: output is throttled")
+)
+
+// 限流配置选项。它对应着组件配置的 [X.rate_limit] 配置项。
+type RateLimitConfig struct {
+	Rate      float64 `toml:"rate"`       // 每秒产生的令牌数量，即每秒允许处理的消息数量
+	Burst     int     `toml:"burst"`      // 令牌桶容量，即允许的突发消息数量。默认为rate，最少为1
+	KeyHeader string  `toml:"key_header"` // 按此Header的值分别限流。为空时，所有消息共用一个令牌桶
+	Policy    string  `toml:"policy"`     // 没有令牌时的处理策略：wait/drop，默认wait
+	MaxKeys   int     `toml:"max_keys"`   // 按Header限流时，最多保留的令牌桶数量，默认10000
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	rate      float64
+	burst     float64
+	keyHeader string
+	wait      bool
+	maxKeys   int
+
+	mu      *sync.Mutex
+	bucket  *tokenBucket
+	buckets map[string]*tokenBucket
+}
+
+// 根据配置创建限流器。未配置，或者rate不大于0时返回nil。
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	if nil == config || 0 >= config.Rate {
+		return nil
+	}
+	limiter := &rateLimiter{
+		rate:      config.Rate,
+		burst:     float64(config.Burst),
+		keyHeader: config.KeyHeader,
+		wait:      RateLimitDrop != config.Policy,
+		maxKeys:   config.MaxKeys,
+		mu:        new(sync.Mutex),
+		buckets:   make(map[string]*tokenBucket),
+	}
+	if 0 >= limiter.burst {
+		limiter.burst = math.Max(1, math.Ceil(config.Rate))
+	}
+	if 0 >= limiter.maxKeys {
+		limiter.maxKeys = defaultRateLimitMaxKeys
+	}
+	limiter.bucket = &tokenBucket{tokens: limiter.burst, last: time.Now()}
+	return limiter
+}
+
+// 为消息申请一个令牌。wait策略下等待令牌，直到消息超过截止时间；drop策略下没有令牌时立即返回。
+// 返回是否获得令牌。
+func (slf *rateLimiter) take(pack *DataFrame) bool {
+	delay, ok := slf.reserve(pack, time.Now())
+	if !ok {
+		return false
+	}
+	if 0 >= delay {
+		return true
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-pack.Context().Done():
+		return false
+	}
+}
+
+// 预留一个令牌，返回需要等待的时间
+func (slf *rateLimiter) reserve(pack *DataFrame, now time.Time) (time.Duration, bool) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	bucket := slf.bucket
+	if "" != slf.keyHeader {
+		key := pack.HeaderOrDefault(slf.keyHeader, "")
+		bucket = slf.buckets[key]
+		if nil == bucket {
+			slf.evict(now)
+			bucket = &tokenBucket{tokens: slf.burst, last: now}
+			slf.buckets[key] = bucket
+		}
+	}
+	// 按时间补充令牌
+	bucket.tokens = math.Min(slf.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*slf.rate)
+	bucket.last = now
+	if 1 <= bucket.tokens {
+		bucket.tokens--
+		return 0, true
+	}
+	if !slf.wait {
+		return 0, false
+	}
+	// 预支令牌，等待令牌补充
+	bucket.tokens--
+	return time.Duration(-bucket.tokens / slf.rate * float64(time.Second)), true
+}
+
+// 令牌桶数量达到上限时，移除已补满的令牌桶
+func (slf *rateLimiter) evict(now time.Time) {
+	if len(slf.buckets) < slf.maxKeys {
+		return
+	}
+	for key, b := range slf.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*slf.rate >= slf.burst {
+			delete(slf.buckets, key)
+		}
+	}
+	// 仍然达到上限时，随机移除一个令牌桶
+	for key := range slf.buckets {
+		if len(slf.buckets) < slf.maxKeys {
+			break
+		}
+		delete(slf.buckets, key)
+	}
+}
@@ -0,0 +1,133 @@
+package gopl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func TestRateLimiter_Drop(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{Rate: 1, Burst: 2, KeyHeader: "device", Policy: RateLimitDrop})
+	newPack := func(device string) *DataFrame {
+		pack := NewDataFrame()
+		pack.SetHeader("device", device)
+		return pack
+	}
+	if !limiter.take(newPack("A")) || !limiter.take(newPack("A")) {
+		t.Fatal("Burst frames should be allowed")
+	}
+	if limiter.take(newPack("A")) {
+		t.Fatal("Frame over burst should be dropped")
+	}
+	if !limiter.take(newPack("B")) {
+		t.Fatal("Other key should have its own bucket")
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := newRateLimiter(&RateLimitConfig{Rate: 50, Burst: 1})
+	limiter.take(NewDataFrame())
+
+	start := time.Now()
+	if !limiter.take(NewDataFrame()) {
+		t.Fatal("Wait policy should wait for token")
+	}
+	if waits := time.Now().Sub(start); waits < time.Millisecond*10 {
+		t.Fatalf("Should wait for token refill, waits: %s", waits)
+	}
+
+	// 消息截止时间早于令牌补充时间
+	pack := NewDataFrame()
+	pack.SetDeadline(time.Now().Add(time.Millisecond))
+	if limiter.take(pack) {
+		t.Fatal("Frame should be dropped when deadline exceeded")
+	}
+}
+
+func TestDelivererProxy_Throttled(t *testing.T) {
+	router := newRouter(1)
+	proxy := &delivererProxy{
+		pipeline: router,
+		state:    newComponentState(),
+		limiter:  newRateLimiter(&RateLimitConfig{Rate: 1, Burst: 1, Policy: RateLimitDrop}),
+	}
+	router.threads.Start()
+	defer router.threads.Shutdown()
+	router.buildRouteTable()
+
+	if err := proxy.TryDeliver(NewDataFrame()); nil != err {
+		t.Fatal(err)
+	}
+	if err := proxy.TryDeliver(NewDataFrame()); ErrDeliverThrottled != err {
+		t.Fatalf("Should be throttled, was: %v", err)
+	}
+	if 1 != router.fio.Throttled() {
+		t.Fatalf("Throttled counter not match, was: %d", router.fio.Throttled())
+	}
+}
+
+func TestOutputRunner_Throttled(t *testing.T) {
+	router := newRouter(1)
+	output := new(testRecordOutput)
+	output.SetName("TestRecordOutput")
+	config := &ComponentConfig{RateLimit: &RateLimitConfig{Rate: 1, Burst: 1, Policy: RateLimitDrop}}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "TestRecordOutput")
+
+	if _, err := router.output1(runner, NewDataFrame()); nil != err {
+		t.Fatal(err)
+	}
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetAckHandler(recorder.handler)
+	if _, err := router.output1(runner, pack); ErrOutputThrottled != err {
+		t.Fatalf("Should be throttled, was: %v", err)
+	}
+	releaseDataFrame(pack)
+	if acks := recorder.results(); 1 != len(acks) || ErrOutputThrottled != acks[0] {
+		t.Fatalf("Ack should receive output throttled error, was: %v", acks)
+	}
+	if 1 != runner.counter.Throttled() {
+		t.Fatalf("Throttled counter not match, was: %d", runner.counter.Throttled())
+	}
+}
+
+func TestOutputRunner_WaitLimitRequiresQueue(t *testing.T) {
+	defer func() {
+		if nil == recover() {
+			t.Fatal("Wait policy without queue_size should panic")
+		}
+	}()
+	config := &ComponentConfig{RateLimit: &RateLimitConfig{Rate: 1}}
+	newOutputRunner(new(testRecordOutput), new(AnyMatcher), config, "TestRecordOutput")
+}
+
+func TestOutputRunner_ThrottleDeadline(t *testing.T) {
+	router := newRouter(1)
+	output := new(testRecordOutput)
+	output.SetName("TestRecordOutput")
+	config := &ComponentConfig{QueueSize: 1, RateLimit: &RateLimitConfig{Rate: 1, Burst: 1}}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "TestRecordOutput")
+
+	if _, err := router.output1(runner, NewDataFrame()); nil != err {
+		t.Fatal(err)
+	}
+	// 等待令牌时消息超时，返回上下文错误
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetDeadline(time.Now().Add(time.Millisecond * 5))
+	pack.SetAckHandler(recorder.handler)
+	if _, err := router.output1(runner, pack); context.DeadlineExceeded != err {
+		t.Fatalf("Should report deadline exceeded, was: %v", err)
+	}
+	releaseDataFrame(pack)
+	if acks := recorder.results(); 1 != len(acks) || context.DeadlineExceeded != acks[0] {
+		t.Fatalf("Ack should receive deadline exceeded, was: %v", acks)
+	}
+	if 0 != runner.counter.Throttled() {
+		t.Fatalf("Deadline exceeded should not be throttled, was: %d", runner.counter.Throttled())
+	}
+}
@@ -0,0 +1,312 @@
+package gopl
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/parkingwang/go-conf"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"reflect"
+	"sort"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 重新加载配置：按组件配置名对比配置变化，只重启配置发生变化的组件
+//
+
+// Reload 重新读取配置目录。新增、删除以及配置发生变化的组件被启动或者停止，配置未变化的组件保持运行。
+// 路由快照在新组件初始化完成后一次性替换。读取配置或者初始化组件失败时，返回错误，并保持当前配置运行。
+func (slf *GoPipeline) Reload() (err error) {
+	slf.reloadMu.Lock()
+	defer slf.reloadMu.Unlock()
+	if slf.stopped.Get() {
+		return errors.New("pipeline is stopped")
+	}
+	withTag(log.Info).Msgf("Reload config dir: %s", slf.configDir)
+
+	// 出错时恢复到当前状态
+	prevRoot, prevGlobals, prevRouter := slf.rootConfig, slf.globalsConfig, slf.routerConfig
+	prevInputs, prevFilters, prevOutputs := slf.inputRunners, slf.filterRunners, slf.outputRunners
+	prevPipelines, prevDeliverTimeout := slf.pipelines, slf.deliverTimeout
+	created := make([]interface{}, 0)
+	committed := false
+	defer func() {
+		if r := recover(); nil != r {
+			err = fmt.Errorf("reload failed: %v", r)
+			if committed {
+				return
+			}
+			slf.rootConfig, slf.globalsConfig, slf.routerConfig = prevRoot, prevGlobals, prevRouter
+			slf.inputRunners, slf.filterRunners, slf.outputRunners = prevInputs, prevFilters, prevOutputs
+			slf.pipelines, slf.deliverTimeout = prevPipelines, prevDeliverTimeout
+			for _, runner := range created {
+				closeRunner(runner)
+			}
+			slf.registerCounters()
+		}
+	}()
+
+	rootConfig := loadConfig(slf.configDir)
+	globals := rootConfig.MustMap("Globals")
+	routerConfig := RouterConfig{}
+	if len(globals) > 0 {
+		if err := conf.Map2Struct(globals, &routerConfig); nil != err {
+			return errors.WithMessage(err, "decode [Globals] config")
+		}
+	}
+	slf.checkRestartRequired(routerConfig)
+
+	// 对比组件配置
+	current := make(map[string]interface{})
+	for _, runners := range []*list.List{slf.inputRunners, slf.filterRunners, slf.outputRunners} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			current[runnerConfigKey(ele.Value)] = ele.Value
+		}
+	}
+	slf.deliverTimeout = DurationValue(routerConfig.DeliverTimeout)
+	kept := make(map[string]interface{})
+	added, changed, removed := make([]string, 0), make([]string, 0), make([]string, 0)
+	for key, val := range rootConfig {
+		// 被管理接口停止的组件，重新创建
+		if runner, ok := current[key]; ok && !runnerDisabled(runner) && reflect.DeepEqual(slf.rootConfig[key], val) {
+			kept[key] = runner
+			continue
+		}
+		runner := slf.newComponentRunner(key, val)
+		if nil == runner {
+			continue
+		}
+		created = append(created, runner)
+		if _, ok := current[key]; ok {
+			changed = append(changed, key)
+		} else {
+			added = append(added, key)
+		}
+	}
+	stale := make([]interface{}, 0)
+	for key, runner := range current {
+		if _, ok := kept[key]; ok {
+			continue
+		}
+		stale = append(stale, runner)
+		if !containsKey(changed, key) {
+			removed = append(removed, key)
+		}
+	}
+
+	// 初始化新组件：Output -> Filter -> Input
+	sort.Slice(created, func(i, j int) bool {
+		return runnerOrder(created[i]) < runnerOrder(created[j])
+	})
+	for _, runner := range created {
+		switch r := runner.(type) {
+		case *outputRunner:
+			detectTimeout(func() { r.init(slf) }, "OutputRunner.Init")
+		case *filterRunner:
+			detectTimeout(func() { r.init(slf) }, "FilterRunner.Init")
+		case *inputRunner:
+			detectTimeout(func() { r.init(slf) }, "InputRunner.Init")
+		}
+	}
+
+	// 建立新的组件列表和路由快照
+	slf.rootConfig = rootConfig
+	slf.globalsConfig = globals
+	// 复制后整体替换配置；处理消息时通过路由快照读取，不直接读取 routerConfig
+	nextConfig := slf.routerConfig
+	nextConfig.FilterMode = routerConfig.FilterMode
+	nextConfig.FilterChain = routerConfig.FilterChain
+	nextConfig.DeliverTimeout = routerConfig.DeliverTimeout
+	nextConfig.DrainTimeout = routerConfig.DrainTimeout
+	nextConfig.DeadLetterTopic = routerConfig.DeadLetterTopic
+	nextConfig.ParallelOutputs = routerConfig.ParallelOutputs
+	slf.routerConfig = nextConfig
+	slf.drainTimeout = DurationOrDefault(routerConfig.DrainTimeout, defaultDrainTimeout)
+	slf.inputRunners, slf.filterRunners, slf.outputRunners = list.New(), list.New(), list.New()
+	for _, runners := range []*list.List{prevInputs, prevFilters, prevOutputs} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			if _, ok := kept[runnerConfigKey(ele.Value)]; ok {
+				slf.addRunner(ele.Value)
+			}
+		}
+	}
+	for _, runner := range created {
+		slf.addRunner(runner)
+	}
+	slf.sortFilterRunners()
+	slf.setupPipelines()
+	prevSnapshot := slf.snapshot.Load().(*routeSnapshot)
+	slf.buildRouteTable()
+	committed = true
+
+	// 停止旧组件：先停止Input，等待旧路由快照的消息处理完成后，再停止Filter和Output
+	for _, runner := range stale {
+		if r, ok := runner.(*inputRunner); ok {
+			closeRunner(r)
+		}
+	}
+	deadline := time.Now().Add(slf.drainTimeout)
+	for 0 < prevSnapshot.refs.Get() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if refs := prevSnapshot.refs.Get(); 0 < refs {
+		withTag(log.Warn).Msgf("Reload: previous routes still in use by %d frames", refs)
+	}
+	for _, runner := range stale {
+		switch r := runner.(type) {
+		case *filterRunner:
+			closeRunner(r)
+		case *outputRunner:
+			closeRunner(r)
+		}
+	}
+	for _, key := range removed {
+		slf.componentCounters.Delete(key)
+		slf.queueCounters.Delete(key)
+	}
+
+	// 启动新组件
+	for _, key := range append(added, changed...) {
+		switch r := slf.findRunner(key).(type) {
+		case *outputRunner:
+			slf.startOutput(r)
+		case *inputRunner:
+			go r.start(slf)
+		}
+	}
+
+	sort.Strings(added)
+	sort.Strings(changed)
+	sort.Strings(removed)
+	withTag(log.Info).Msgf("Reload COMPLETED, added: %s, changed: %s, removed: %s", added, changed, removed)
+	return nil
+}
+
+// [Globals] 中需要重启才能生效的配置，发生变化时输出警告
+func (slf *GoPipeline) checkRestartRequired(next RouterConfig) {
+	prev := slf.routerConfig
+	if prev.Backpressure != next.Backpressure || prev.MaxPending != next.MaxPending ||
+		prev.SpillDir != next.SpillDir || prev.SpillMaxBytes != next.SpillMaxBytes {
+		withTag(log.Warn).Msg("Reload: backpressure config changed, requires restart")
+	}
+	if prev.OrderingKey != next.OrderingKey || prev.OrderingLanes != next.OrderingLanes {
+		withTag(log.Warn).Msg("Reload: ordering config changed, requires restart")
+	}
+	if !reflect.DeepEqual(prev.PriorityWeights, next.PriorityWeights) || prev.PriorityQueueSize != next.PriorityQueueSize {
+		withTag(log.Warn).Msg("Reload: priority config changed, requires restart")
+	}
+	if prev.WalDir != next.WalDir || prev.WalMaxBytes != next.WalMaxBytes ||
+		prev.WalSegmentBytes != next.WalSegmentBytes || prev.WalRetention != next.WalRetention {
+		withTag(log.Warn).Msg("Reload: wal config changed, requires restart")
+	}
+}
+
+// 重新注册当前组件的统计数据
+func (slf *GoPipeline) registerCounters() {
+	for _, runners := range []*list.List{slf.filterRunners, slf.outputRunners} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			switch r := ele.Value.(type) {
+			case *filterRunner:
+				slf.componentCounters.Store(r.configKey, r.counter)
+			case *outputRunner:
+				slf.componentCounters.Store(r.configKey, r.counter)
+				if nil != r.queue {
+					slf.queueCounters.Store(r.configKey, r.queue.counter)
+				}
+			}
+		}
+	}
+}
+
+// 停止组件，并标记为已停止。Output的独立队列先处理完成剩余的消息。已被管理接口停止的组件，不再重复停止。
+func closeRunner(runner interface{}) {
+	if runnerDisabled(runner) {
+		return
+	}
+	// 停止后组件标记为已停止，旧的投递代理拒绝后续投递的消息
+	defer runnerState(runner).disable()
+	switch r := runner.(type) {
+	case *inputRunner:
+		if _, ok := r.input.(NeedShutdown); !ok {
+			withTag(log.Warn).Msgf("Input: <%s> NOT support Shutdown, keep running", r.configKey)
+		}
+		r.disable()
+		closeSlot(r.input)
+
+	case *filterRunner:
+		closeSlot(r.filter)
+
+	case *outputRunner:
+		if nil != r.queue {
+			r.queue.close()
+		}
+		if nil != r.batch {
+			r.batch.flush()
+		}
+		if nil != r.spool {
+			r.spool.close()
+		}
+		closeSlot(r.output)
+	}
+}
+
+func runnerConfigKey(runner interface{}) string {
+	switch r := runner.(type) {
+	case *inputRunner:
+		return r.configKey
+	case *filterRunner:
+		return r.configKey
+	case *outputRunner:
+		return r.configKey
+	default:
+		return ""
+	}
+}
+
+func runnerDisabled(runner interface{}) bool {
+	switch r := runner.(type) {
+	case *inputRunner:
+		return r.isDisabled()
+	case *filterRunner:
+		return r.isDisabled()
+	case *outputRunner:
+		return r.isDisabled()
+	default:
+		return false
+	}
+}
+
+// 组件的初始化顺序
+func runnerOrder(runner interface{}) int {
+	switch runner.(type) {
+	case *outputRunner:
+		return 0
+	case *filterRunner:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// 根据配置名查找组件的Runner
+func (slf *GoPipeline) findRunner(configKey string) interface{} {
+	for _, runners := range []*list.List{slf.inputRunners, slf.filterRunners, slf.outputRunners} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			if configKey == runnerConfigKey(ele.Value) {
+				return ele.Value
+			}
+		}
+	}
+	return nil
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
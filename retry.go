@@ -0,0 +1,159 @@
+package gopl
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// Output处理失败时，按指数退避重试
+//
+
+// 重试处理时，消息Header中记录的重试次数
+const HeaderRetryCount = "Retry-Count"
+
+// Output重试配置选项。它对应着组件配置的 [X.Retry] 配置项。
+type RetryConfig struct {
+	MaxAttempts    int      `toml:"max_attempts"`    // 最多处理次数，包含首次处理。默认3
+	InitialBackoff string   `toml:"initial_backoff"` // 首次重试前的等待时间，默认100ms
+	MaxBackoff     string   `toml:"max_backoff"`     // 重试等待时间的上限，默认10s
+	Multiplier     float64  `toml:"multiplier"`      // 每次重试等待时间的增长倍数，默认2
+	Jitter         float64  `toml:"jitter"`          // 等待时间的随机抖动比例，0~1，默认0.2
+	RetryOn        []string `toml:"retry_on"`        // 可重试错误的错误信息关键字。为空时，除不可重试的错误外均重试
+}
+
+// RetryableError 错误可以实现此接口，声明是否可以重试
+type RetryableError interface {
+	Retryable() bool
+}
+
+type nonRetryableError struct {
+	error
+}
+
+func (nonRetryableError) Retryable() bool {
+	return false
+}
+
+// NonRetryable 将错误标记为不可重试。Output返回此错误时，Router不再重试。
+func NonRetryable(err error) error {
+	if nil == err {
+		return nil
+	}
+	return nonRetryableError{err}
+}
+
+type retryPolicy struct {
+	maxAttempts int
+	initial     time.Duration
+	max         time.Duration
+	multiplier  float64
+	jitter      float64
+	retryOn     []string
+}
+
+// 根据配置创建重试策略。未配置时返回nil。
+func newRetryPolicy(config *RetryConfig) *retryPolicy {
+	if nil == config {
+		return nil
+	}
+	policy := &retryPolicy{
+		maxAttempts: config.MaxAttempts,
+		initial:     DurationOrDefault(config.InitialBackoff, time.Millisecond*100),
+		max:         DurationOrDefault(config.MaxBackoff, time.Second*10),
+		multiplier:  config.Multiplier,
+		jitter:      config.Jitter,
+		retryOn:     config.RetryOn,
+	}
+	if 0 >= policy.maxAttempts {
+		policy.maxAttempts = 3
+	}
+	if 1 > policy.multiplier {
+		policy.multiplier = 2
+	}
+	if 0 >= policy.jitter || 1 < policy.jitter {
+		policy.jitter = 0.2
+	}
+	return policy
+}
+
+// 返回第N次重试前的等待时间
+func (slf *retryPolicy) backoff(retry int) time.Duration {
+	wait := float64(slf.initial)
+	for i := 1; i < retry && wait < float64(slf.max); i++ {
+		wait *= slf.multiplier
+	}
+	if wait > float64(slf.max) {
+		wait = float64(slf.max)
+	}
+	// 在 [1-jitter, 1+jitter] 范围内随机抖动，避免多个Output同时重试
+	wait *= 1 + slf.jitter*(rand.Float64()*2-1)
+	return time.Duration(wait)
+}
+
+// 获取错误或其原因实现的 RetryableError 接口
+func asRetryableError(err error) (RetryableError, bool) {
+	if re, ok := err.(RetryableError); ok {
+		return re, true
+	}
+	re, ok := errors.Cause(err).(RetryableError)
+	return re, ok
+}
+
+// 判断错误是否被声明为不可重试
+func isNonRetryable(err error) bool {
+	re, ok := asRetryableError(err)
+	return ok && !re.Retryable()
+}
+
+// 判断错误是否可以重试。消息超时或者被取消时，不再重试。
+func (slf *retryPolicy) retryable(err error) bool {
+	if re, ok := asRetryableError(err); ok {
+		return re.Retryable()
+	}
+	cause := errors.Cause(err)
+	if context.DeadlineExceeded == cause || context.Canceled == cause {
+		return false
+	}
+	if 0 == len(slf.retryOn) {
+		return true
+	}
+	msg := err.Error()
+	for _, keyword := range slf.retryOn {
+		if strings.Contains(msg, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// 按重试策略重新处理消息，返回最后一次处理的错误。
+// 重试时使用消息的副本，并在副本Header中记录重试次数，避免修改被其它Output共享的消息。
+func (slf *outputRunner) retryOutput(pack *DataFrame, err error) error {
+	for retry := 1; retry < slf.retry.maxAttempts && slf.retry.retryable(err); retry++ {
+		if slf.isDisabled() {
+			return err
+		}
+		wait := slf.retry.backoff(retry)
+		withTag(log.Warn).Err(err).Msgf("Output: <%s> RETRY(%d/%d) after %s", slf.configKey, retry, slf.retry.maxAttempts-1, wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-pack.Context().Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		retried := pack.derive()
+		retried.SetHeader(HeaderRetryCount, strconv.Itoa(retry))
+		slf.counter.increaseRetries()
+		err = slf.outputCtx.OutputContext(retried.Context(), retried)
+		releaseDataFrame(retried)
+	}
+	return err
+}
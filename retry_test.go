@@ -0,0 +1,110 @@
+package gopl
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 前N次处理失败的Output
+type testFlakyOutput struct {
+	AbcSlot
+	failures int
+	err      error
+	attempts int
+	retries  []string
+}
+
+func (slf *testFlakyOutput) Output(pack *DataFrame) {
+}
+
+func (slf *testFlakyOutput) OutputContext(ctx context.Context, pack *DataFrame) error {
+	slf.attempts++
+	slf.retries = append(slf.retries, pack.HeaderOrDefault(HeaderRetryCount, ""))
+	if slf.attempts <= slf.failures {
+		return slf.err
+	}
+	return nil
+}
+
+func TestOutputRunner_Retry(t *testing.T) {
+	output := &testFlakyOutput{failures: 2, err: errors.New("connection reset")}
+	config := &ComponentConfig{QueueSize: 1, Retry: &RetryConfig{MaxAttempts: 3, InitialBackoff: "1ms"}}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "FlakyOutput")
+
+	pack := NewDataFrame()
+	if err := runner.runOutput(pack); nil != err {
+		t.Fatalf("Should success after retry, was: %s", err)
+	}
+	if 3 != output.attempts || "" != output.retries[0] || "1" != output.retries[1] || "2" != output.retries[2] {
+		t.Fatalf("Retry attempts not match, was: %v", output.retries)
+	}
+	if _, set := pack.Header(HeaderRetryCount); set {
+		t.Fatal("Original frame should not be modified")
+	}
+	if 2 != runner.counter.Retries() || 1 != runner.counter.Handled() || 0 != runner.counter.Errors() {
+		t.Fatalf("Counter not match, retries: %d, handled: %d", runner.counter.Retries(), runner.counter.Handled())
+	}
+}
+
+func TestOutputRunner_RetryClassify(t *testing.T) {
+	config := &ComponentConfig{QueueSize: 1, Retry: &RetryConfig{MaxAttempts: 5, InitialBackoff: "1ms", RetryOn: []string{"timeout"}}}
+
+	// 错误信息不包含可重试关键字
+	output := &testFlakyOutput{failures: 5, err: errors.New("invalid payload")}
+	runner := newOutputRunner(output, new(AnyMatcher), config, "FlakyOutput")
+	if err := runner.runOutput(NewDataFrame()); nil == err || 1 != output.attempts {
+		t.Fatalf("Should not retry, attempts: %d", output.attempts)
+	}
+
+	// 不可重试的错误
+	output = &testFlakyOutput{failures: 5, err: NonRetryable(errors.New("timeout"))}
+	runner = newOutputRunner(output, new(AnyMatcher), config, "FlakyOutput")
+	if err := runner.runOutput(NewDataFrame()); nil == err || 1 != output.attempts {
+		t.Fatalf("Should not retry non-retryable error, attempts: %d", output.attempts)
+	}
+
+	// 达到最多处理次数
+	output = &testFlakyOutput{failures: 10, err: errors.New("read timeout")}
+	runner = newOutputRunner(output, new(AnyMatcher), config, "FlakyOutput")
+	if err := runner.runOutput(NewDataFrame()); nil == err || 5 != output.attempts {
+		t.Fatalf("Should stop at max attempts, attempts: %d", output.attempts)
+	}
+	if 1 != runner.counter.Errors() || 4 != runner.counter.Retries() {
+		t.Fatalf("Counter not match, errors: %d, retries: %d", runner.counter.Errors(), runner.counter.Retries())
+	}
+}
+
+func TestOutputRunner_RetryRequiresQueue(t *testing.T) {
+	defer func() {
+		if nil == recover() {
+			t.Fatal("Retry without queue_size should panic")
+		}
+	}()
+	config := &ComponentConfig{Retry: &RetryConfig{MaxAttempts: 3}}
+	newOutputRunner(new(testFlakyOutput), new(AnyMatcher), config, "FlakyOutput")
+}
+
+func TestOutputRunner_RetryRejectsBatchOutput(t *testing.T) {
+	defer func() {
+		if nil == recover() {
+			t.Fatal("Retry on BatchOutput should panic")
+		}
+	}()
+	config := &ComponentConfig{QueueSize: 1, Retry: &RetryConfig{MaxAttempts: 3}}
+	newOutputRunner(new(testBatchOutput), new(AnyMatcher), config, "TestBatchOutput")
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := newRetryPolicy(&RetryConfig{InitialBackoff: "100ms", MaxBackoff: "1s", Jitter: 0.1})
+	for retry, expected := range map[int]float64{1: 100, 2: 200, 3: 400, 4: 800, 5: 1000, 10: 1000} {
+		wait := float64(policy.backoff(retry).Nanoseconds()) / 1e6
+		if wait < expected*0.9 || wait > expected*1.1 {
+			t.Fatalf("Backoff of retry %d not match, was: %.1fms", retry, wait)
+		}
+	}
+}
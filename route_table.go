@@ -0,0 +1,143 @@
+package gopl
+
+import (
+	"sort"
+	"sync"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 预编译的Topic路由表
+//
+
+// 路由表项。不需要匹配的项，表示组件已通过Topic确定接受此消息。
+type routeEntry struct {
+	needMatch bool
+	filter    *filterRunner
+	output    *outputRunner
+}
+
+// 某个Topic的路由结果，按组件的处理顺序排列
+type topicRoute struct {
+	filters []routeEntry
+	outputs []routeEntry
+}
+
+// 路由表在Setup时根据组件的Matcher建立：
+// DefaultURLMatcher 按Topic的Path建立Hash索引；其它Matcher（AnyMatcher、自定义Matcher等）放入通配列表。
+// 每个Topic的路由结果会被缓存，Topic由配置文件声明，数量是有限的。
+type routeTable struct {
+	filters     []*filterRunner
+	outputs     []*outputRunner
+	filterIndex *routeIndex
+	outputIndex *routeIndex
+	cache       *sync.Map // topic -> *topicRoute
+}
+
+func newRouteTable(filters []*filterRunner, outputs []*outputRunner) *routeTable {
+	filterMatchers := make([]Matcher, len(filters))
+	for i, fr := range filters {
+		filterMatchers[i] = fr.matcher
+	}
+	outputMatchers := make([]Matcher, len(outputs))
+	for i, or := range outputs {
+		outputMatchers[i] = or.matcher
+	}
+	return &routeTable{
+		filters:     filters,
+		outputs:     outputs,
+		filterIndex: newRouteIndex(filterMatchers),
+		outputIndex: newRouteIndex(outputMatchers),
+		cache:       new(sync.Map),
+	}
+}
+
+// 查找Topic的路由结果
+func (slf *routeTable) lookup(topic string) *topicRoute {
+	if route, ok := slf.cache.Load(topic); ok {
+		return route.(*topicRoute)
+	}
+	filterIdx := slf.filterIndex.candidates(topic)
+	outputIdx := slf.outputIndex.candidates(topic)
+	route := &topicRoute{
+		filters: make([]routeEntry, 0, len(filterIdx)),
+		outputs: make([]routeEntry, 0, len(outputIdx)),
+	}
+	for _, i := range filterIdx {
+		fr := slf.filters[i]
+		route.filters = append(route.filters, routeEntry{needMatch: needMatch(fr.matcher), filter: fr})
+	}
+	for _, i := range outputIdx {
+		or := slf.outputs[i]
+		route.outputs = append(route.outputs, routeEntry{needMatch: needMatch(or.matcher), output: or})
+	}
+	actual, _ := slf.cache.LoadOrStore(topic, route)
+	return actual.(*topicRoute)
+}
+
+////
+
+// 路由快照。Setup和Reload时建立，快照建立后不再改变。
+// 消息处理期间持有快照的引用，Reload替换快照后，等待旧快照的引用释放，才停止被移除的组件。
+type routeSnapshot struct {
+	mode       string           // Filter处理模式
+	pipelines  []*pipelineRoute // 命名管道
+	routes     *routeTable      // Topic路由表
+	filters    int              // Filter数量
+	parallel   bool             // 是否并行处理消息的多个Output
+	deadLetter string           // 死信Topic，为空时不投递
+	components []interface{}    // 所有组件的Runner，按 Input、Filter、Output 的顺序排列，用于健康检查
+	refs       *AtomicInt64     // 正在使用此快照处理的消息数量
+}
+
+func (slf *routeSnapshot) release() {
+	slf.refs.Add(-1)
+}
+
+////
+
+// 组件Matcher的索引。索引值为组件在处理顺序中的位置。
+type routeIndex struct {
+	exact map[string][]int // Topic Path -> 组件位置
+	wild  []int            // 需要对每个消息调用Match的组件位置
+}
+
+func newRouteIndex(matchers []Matcher) *routeIndex {
+	index := &routeIndex{
+		exact: make(map[string][]int),
+		wild:  make([]int, 0),
+	}
+	for i, matcher := range matchers {
+		if m, ok := matcher.(*DefaultURLMatcher); ok {
+			index.exact[m.path] = append(index.exact[m.path], i)
+		} else {
+			index.wild = append(index.wild, i)
+		}
+	}
+	return index
+}
+
+// 返回可能接受此Topic消息的组件位置，按处理顺序排列
+func (slf *routeIndex) candidates(topic string) []int {
+	exact := slf.exact[topic]
+	out := make([]int, 0, len(exact)+len(slf.wild))
+	out = append(out, exact...)
+	out = append(out, slf.wild...)
+	sort.Ints(out)
+	return out
+}
+
+// 返回组件是否需要对每个消息调用Match来确认
+func needMatch(matcher Matcher) bool {
+	switch m := matcher.(type) {
+	case *AnyMatcher:
+		return false
+
+	case *DefaultURLMatcher:
+		// Path已通过索引匹配，只需要匹配Header参数
+		return 0 < len(m.query)
+
+	default:
+		return true
+	}
+}
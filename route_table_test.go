@@ -0,0 +1,76 @@
+package gopl
+
+import (
+	"fmt"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func newTestOutputRunners(num int) []*outputRunner {
+	outputs := make([]*outputRunner, 0, num)
+	for i := 0; i < num; i++ {
+		name := fmt.Sprintf("Output%d", i)
+		matcher, _ := NewDefaultURLMatcher(fmt.Sprintf("/test/topic/%d", i))
+		outputs = append(outputs, newOutputRunner(new(testRecordOutput), matcher, &ComponentConfig{}, name))
+	}
+	return outputs
+}
+
+func TestRouteTable_Lookup(t *testing.T) {
+	outputs := newTestOutputRunners(10)
+	header, _ := NewDefaultURLMatcher("/test/topic/3?version=2018")
+	outputs = append(outputs,
+		newOutputRunner(new(testRecordOutput), new(AnyMatcher), &ComponentConfig{}, "Any"),
+		newOutputRunner(new(testRecordOutput), header, &ComponentConfig{}, "Header"),
+	)
+	table := newRouteTable(nil, outputs)
+
+	route := table.lookup("/test/topic/3")
+	if 3 != len(route.outputs) {
+		t.Fatalf("Route outputs not match, was: %d", len(route.outputs))
+	}
+	if "Output3" != route.outputs[0].output.configKey || route.outputs[0].needMatch {
+		t.Fatal("Exact topic route not match")
+	}
+	if "Any" != route.outputs[1].output.configKey || route.outputs[1].needMatch {
+		t.Fatal("Any matcher route not match")
+	}
+	if "Header" != route.outputs[2].output.configKey || !route.outputs[2].needMatch {
+		t.Fatal("Header matcher route not match")
+	}
+	if route != table.lookup("/test/topic/3") {
+		t.Fatal("Route should be cached")
+	}
+	if 1 != len(table.lookup("/test/unknown").outputs) {
+		t.Fatal("Unknown topic should only route to AnyMatcher")
+	}
+}
+
+func BenchmarkRouteTable_Lookup(b *testing.B) {
+	table := newRouteTable(nil, newTestOutputRunners(64))
+	pack := NewDataFrame()
+	pack.setTopic("/test/topic/32")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, entry := range table.lookup(pack.Topic()).outputs {
+			if entry.needMatch {
+				entry.output.checkAccept(pack)
+			}
+		}
+	}
+}
+
+func BenchmarkRouteTable_LinearMatch(b *testing.B) {
+	outputs := newTestOutputRunners(64)
+	pack := NewDataFrame()
+	pack.setTopic("/test/topic/32")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, or := range outputs {
+			or.checkAccept(pack)
+		}
+	}
+}
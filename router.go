@@ -3,6 +3,7 @@ package gopl
 import (
 	"bytes"
 	"container/list"
+	"context"
 	"fmt"
 	"github.com/parkingwang/go-conf"
 	"github.com/pelletier/go-toml"
@@ -13,7 +14,11 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -22,10 +27,14 @@ import (
 //
 
 type GoPipeline struct {
+	configDir            string
 	rootConfig           conf.Map
 	globalsConfig        conf.Map
 	debugConfig          DebugConfig
 	debugDetectBlockTime time.Duration
+	routerConfig         RouterConfig
+	deliverTimeout       time.Duration
+	drainTimeout         time.Duration
 
 	startupHook  *list.List
 	shutdownHook *list.List
@@ -36,9 +45,27 @@ type GoPipeline struct {
 
 	plugins *list.List
 
+	pipelines []*pipelineRoute // 命名管道。未声明管道时，使用Topic匹配路由消息
+	snapshot  *atomic.Value    // 当前的路由快照 *routeSnapshot，在Setup和Reload时建立
+	reloadMu  *sync.Mutex      // Reload与Shutdown互斥
+	ingress   *ingress         // 入口队列。背压策略为block时为nil
+	wal       *writeAhead      // 预写日志。未配置 wal_dir 时为nil
+	lanes     *orderingLanes   // 顺序处理通道。未配置 ordering_key 时为nil
+	priority  *priorityLanes   // 优先级队列。未配置 priority_weights 时为nil
+	admin     *adminServer     // 管理接口。未配置 [Admin] 时为nil
+
 	threads *goes.GoesPool
 	signals chan os.Signal
 
+	inflight *AtomicInt64   // 已派发到协程池，尚未处理完成的消息数量
+	running  *AtomicBoolean // 启动完成，开始停止时清除。用于就绪检查
+	stopped  *AtomicBoolean // 停止等待超时后，放弃处理剩余的消息
+
+	fio               *FioCounter       // 消息数据统计
+	samples           *DataFrameSamples // 消息处理耗时采样
+	queueCounters     *sync.Map         // Output配置名 -> *QueueCounter
+	componentCounters *sync.Map         // 组件配置名 -> *ComponentCounter
+
 	decoders map[string]Decoder
 	matchers map[string]Matcher
 
@@ -47,12 +74,56 @@ type GoPipeline struct {
 	factoryFilters map[string]FilterFactory
 }
 
-var gSharedRouter = newRouter(runtime.NumCPU() * 8)
+// Pipeline实例配置选项
+type Options struct {
+	MaxGoroutines int // 处理消息的协程最大数量，默认为 CPU数量 * 8
+}
+
+// New 创建一个独立的Pipeline实例。实例拥有独立的组件注册表、配置和统计数据。
+func New(opts Options) *GoPipeline {
+	if 0 >= opts.MaxGoroutines {
+		opts.MaxGoroutines = runtime.NumCPU() * 8
+	}
+	return newRouter(opts.MaxGoroutines)
+}
+
+var gSharedRouter = New(Options{})
 
+// SharedRouter 返回默认的Pipeline实例。包级别的配置和统计函数，均读取此实例。
 func SharedRouter() *GoPipeline {
 	return gSharedRouter
 }
 
+// FioCounter 返回实例的消息数据统计
+func (slf *GoPipeline) FioCounter() *FioCounter {
+	return slf.fio
+}
+
+// Samples 返回实例的消息处理耗时采样
+func (slf *GoPipeline) Samples() *DataFrameSamples {
+	return slf.samples
+}
+
+// QueueCounters 返回实例中所有启用了独立队列的Output的队列统计
+func (slf *GoPipeline) QueueCounters() []*QueueCounter {
+	out := make([]*QueueCounter, 0)
+	slf.queueCounters.Range(func(_, v interface{}) bool {
+		out = append(out, v.(*QueueCounter))
+		return true
+	})
+	return out
+}
+
+// ComponentCounters 返回实例中所有Filter和Output组件的消息处理统计
+func (slf *GoPipeline) ComponentCounters() []*ComponentCounter {
+	out := make([]*ComponentCounter, 0)
+	slf.componentCounters.Range(func(_, v interface{}) bool {
+		out = append(out, v.(*ComponentCounter))
+		return true
+	})
+	return out
+}
+
 // 加载预设组件
 func (slf *GoPipeline) Prepare(prepares ...func(router *GoPipeline)) {
 	slf.AutoRegister(new(JSONDecoder))
@@ -84,20 +155,30 @@ func (slf *GoPipeline) Setup(path string) {
 		withTag(log.Info).Msgf("Registered Output: <%s>", on)
 	}
 
+	// 组件列表
+	for componentKey, val := range slf.rootConfig {
+		if runner := slf.newComponentRunner(componentKey, val); nil != runner {
+			slf.addRunner(runner)
+		}
+	}
+
+	slf.sortFilterRunners()
+	slf.setupPipelines()
+	slf.buildRouteTable()
+}
+
+// 根据组件配置创建组件的Runner。非组件配置，或者组件被禁用时，返回nil。
+func (slf *GoPipeline) newComponentRunner(componentKey string, val interface{}) interface{} {
 	ifComponentEnabled := func(configKey string) (*ComponentConfig, bool) {
 		config := ComponentConfig{}
-		if m, ok := slf.rootConfig[configKey]; !ok {
+		if err := conf.Map2Struct(val, &config); nil != err {
+			withTag(log.Error).Err(err).Msg("Decode map to ComponentConfig FAILED")
 			return nil, false
 		} else {
-			if err := conf.Map2Struct(m, &config); nil != err {
-				withTag(log.Error).Err(err).Msg("Decode map to ComponentConfig FAILED")
-				return nil, false
-			} else {
-				// 默认的插件类型为当前的配置名。
-				// 即实现插件类型名即可作为配置名；而同时配置同一类型插件时，需要使用不同的配置名。
-				if "" == config.ComponentType {
-					config.ComponentType = configKey
-				}
+			// 默认的插件类型为当前的配置名。
+			// 即实现插件类型名即可作为配置名；而同时配置同一类型插件时，需要使用不同的配置名。
+			if "" == config.ComponentType {
+				config.ComponentType = configKey
 			}
 		}
 
@@ -110,51 +191,168 @@ func (slf *GoPipeline) Setup(path string) {
 		return &config, true
 	}
 
-	// 组件列表
-	for componentKey, val := range slf.rootConfig {
-		// 判断是否为组件配置字段
-		config, is := val.(map[string]interface{})
-		if !is {
-			continue
+	// 判断是否为组件配置字段
+	config, is := val.(map[string]interface{})
+	if !is {
+		return nil
+	}
+	cType, cTypeName, ok := slf.ifCoreComponentConfig(componentKey, config)
+	if !ok {
+		return nil
+	}
+
+	// 根据解析出来的参数，创建组件
+	switch cType {
+	case componentInput:
+		if config, en := ifComponentEnabled(componentKey); en {
+			factory, _ := slf.factoryInputs[cTypeName]
+			input := factory()
+			decoder := slf.findNonNilDecoder(input, config, componentKey)
+			withTag(log.Info).Msgf("Working Input: <%s>", componentKey)
+			return newInputRunner(input, decoder, config, componentKey, slf.deliverTimeout)
 		}
-		cType, cTypeName, ok := ifCoreComponentConfig(componentKey, config)
-		if !ok {
-			continue
+
+	case componentFilter:
+		if cnf, en := ifComponentEnabled(componentKey); en {
+			factory, _ := slf.factoryFilters[cTypeName]
+			filter := factory()
+			matcher := slf.findNonNilMatcher(filter, cnf)
+			withTag(log.Info).Msgf("Working Filter: <%s>", componentKey)
+			return newFilterRunner(filter, matcher, cnf, componentKey)
 		}
 
-		// 根据解析出来的参数，注册组件到内核
-		switch cType {
-		case componentInput:
-			if config, en := ifComponentEnabled(componentKey); en {
-				factory, _ := slf.factoryInputs[cTypeName]
-				input := factory()
-				decoder := findNonNilDecoder(input, config, componentKey)
-				slf.inputRunners.PushBack(newInputRunner(input, decoder, config, componentKey))
-				withTag(log.Info).Msgf("Working Input: <%s>", componentKey)
-			}
+	case componentOutput:
+		if cnf, en := ifComponentEnabled(componentKey); en {
+			newOutputFactory, _ := slf.factoryOutputs[cTypeName]
+			output := newOutputFactory()
+			matcher := slf.findNonNilMatcher(output, cnf)
+			withTag(log.Info).Msgf("Working Output: <%s>", componentKey)
+			return newOutputRunner(output, matcher, cnf, componentKey)
+		}
+	}
+	return nil
+}
 
-		case componentFilter:
-			if cnf, en := ifComponentEnabled(componentKey); en {
-				factory, _ := slf.factoryFilters[cTypeName]
-				filter := factory()
-				matcher := findNonNilMatcher(filter, cnf)
-				fr := newFilterRunner(filter, matcher, cnf, componentKey)
-				slf.filterRunners.PushBack(fr)
-				withTag(log.Info).Msgf("Working Filter: <%s>", componentKey)
-			}
+// 添加组件Runner，并注册组件的统计数据
+func (slf *GoPipeline) addRunner(runner interface{}) {
+	switch r := runner.(type) {
+	case *inputRunner:
+		slf.inputRunners.PushBack(r)
 
-		case componentOutput:
-			if cnf, en := ifComponentEnabled(componentKey); en {
-				newOutputFactory, _ := slf.factoryOutputs[cTypeName]
-				output := newOutputFactory()
-				matcher := findNonNilMatcher(output, cnf)
-				or := newOutputRunner(output, matcher, cnf, componentKey)
-				slf.outputRunners.PushBack(or)
-				withTag(log.Info).Msgf("Working Output: <%s>", componentKey)
-			}
+	case *filterRunner:
+		slf.filterRunners.PushBack(r)
+		slf.componentCounters.Store(r.configKey, r.counter)
+
+	case *outputRunner:
+		slf.outputRunners.PushBack(r)
+		slf.componentCounters.Store(r.configKey, r.counter)
+		if nil != r.queue {
+			slf.queueCounters.Store(r.configKey, r.queue.counter)
 		}
 	}
+}
 
+// 根据Filter和Output的Matcher，建立Topic路由表
+func (slf *GoPipeline) buildRouteTable() {
+	filters := make([]*filterRunner, 0, slf.filterRunners.Len())
+	for ele := slf.filterRunners.Front(); ele != nil; ele = ele.Next() {
+		filters = append(filters, ele.Value.(*filterRunner))
+	}
+	outputs := make([]*outputRunner, 0, slf.outputRunners.Len())
+	for ele := slf.outputRunners.Front(); ele != nil; ele = ele.Next() {
+		outputs = append(outputs, ele.Value.(*outputRunner))
+	}
+	slf.checkOrdering(outputs)
+	components := make([]interface{}, 0, slf.inputRunners.Len()+len(filters)+len(outputs))
+	for _, runners := range []*list.List{slf.inputRunners, slf.filterRunners, slf.outputRunners} {
+		for ele := runners.Front(); ele != nil; ele = ele.Next() {
+			components = append(components, ele.Value)
+		}
+	}
+	slf.snapshot.Store(&routeSnapshot{
+		mode:       slf.routerConfig.FilterMode,
+		pipelines:  slf.pipelines,
+		routes:     newRouteTable(filters, outputs),
+		filters:    len(filters),
+		parallel:   slf.routerConfig.ParallelOutputs,
+		deadLetter: slf.routerConfig.DeadLetterTopic,
+		components: components,
+		refs:       NewAtomicInt64(),
+	})
+}
+
+// 配置了 ordering_key 时，并行处理Output或者Output的多个队列协程会打乱相同Key的消息顺序，不允许同时配置
+func (slf *GoPipeline) checkOrdering(outputs []*outputRunner) {
+	if nil == slf.lanes {
+		return
+	}
+	if slf.routerConfig.ParallelOutputs {
+		withTag(log.Panic).Msg("Globals: <ordering_key> can not be used with <parallel_outputs>")
+	}
+	for _, or := range outputs {
+		if nil != or.queue && 1 < or.queue.workers {
+			withTag(log.Panic).Msgf("Output: <%s> <workers> must be 1 when <ordering_key> is set", or.configKey)
+		}
+	}
+}
+
+// 获取当前的路由快照，并增加引用计数。使用完成后需要调用 release 释放。
+func (slf *GoPipeline) acquireSnapshot() *routeSnapshot {
+	for {
+		snap := slf.snapshot.Load().(*routeSnapshot)
+		snap.refs.Add(1)
+		// 增加引用计数期间快照被替换，则重新获取
+		if snap == slf.snapshot.Load().(*routeSnapshot) {
+			return snap
+		}
+		snap.refs.Add(-1)
+	}
+}
+
+// 根据 filter_chain 配置，排列Filter的处理顺序。未在配置中声明的Filter，按配置名排列在后面。
+func (slf *GoPipeline) sortFilterRunners() {
+	switch slf.routerConfig.FilterMode {
+	case "":
+		slf.routerConfig.FilterMode = FilterModeFanout
+	case FilterModeFanout, FilterModeChain:
+	default:
+		withTag(log.Panic).Msgf("Invalid filter_mode: <%s>", slf.routerConfig.FilterMode)
+	}
+
+	declared := make(map[string]*filterRunner)
+	undeclared := make([]*filterRunner, 0)
+	for ele := slf.filterRunners.Front(); ele != nil; ele = ele.Next() {
+		fr := ele.Value.(*filterRunner)
+		declared[fr.configKey] = fr
+	}
+	sorted := list.New()
+	for _, key := range slf.routerConfig.FilterChain {
+		if fr, ok := declared[key]; ok {
+			sorted.PushBack(fr)
+			delete(declared, key)
+		} else {
+			withTag(log.Warn).Msgf("Filter: <%s> declared in filter_chain, but NOT WORKING", key)
+		}
+	}
+	for _, fr := range declared {
+		undeclared = append(undeclared, fr)
+	}
+	sort.Slice(undeclared, func(i, j int) bool {
+		return undeclared[i].configKey < undeclared[j].configKey
+	})
+	for _, fr := range undeclared {
+		if FilterModeChain == slf.routerConfig.FilterMode {
+			withTag(log.Warn).Msgf("Filter: <%s> NOT declared in filter_chain, append to the end", fr.configKey)
+		}
+		sorted.PushBack(fr)
+	}
+	slf.filterRunners = sorted
+
+	names := make([]string, 0, sorted.Len())
+	for ele := sorted.Front(); ele != nil; ele = ele.Next() {
+		names = append(names, ele.Value.(*filterRunner).configKey)
+	}
+	withTag(log.Info).Msgf("Filter mode: <%s>, order: %s", slf.routerConfig.FilterMode, names)
 }
 
 func (slf *GoPipeline) Init() {
@@ -163,11 +361,16 @@ func (slf *GoPipeline) Init() {
 
 	// 插件
 	for ele := slf.plugins.Front(); ele != nil; ele = ele.Next() {
+		if need, ok := ele.Value.(NeedPipeline); ok {
+			need.SetPipeline(slf)
+		}
 		detectTimeout(ele.Value.(Plugin).Init, "Plugin.Init")
 	}
 	// Outputs
 	for ele := slf.outputRunners.Front(); ele != nil; ele = ele.Next() {
-		detectTimeout(ele.Value.(*outputRunner).init, "OutputRunner.Init")
+		detectTimeout(func() {
+			ele.Value.(*outputRunner).init(slf)
+		}, "OutputRunner.Init")
 	}
 	// Filters
 	for ele := slf.filterRunners.Front(); ele != nil; ele = ele.Next() {
@@ -177,18 +380,16 @@ func (slf *GoPipeline) Init() {
 	}
 	// Inputs
 	for ele := slf.inputRunners.Front(); ele != nil; ele = ele.Next() {
-		detectTimeout(ele.Value.(*inputRunner).init, "InputRunner.Init")
+		detectTimeout(func() {
+			ele.Value.(*inputRunner).init(slf)
+		}, "InputRunner.Init")
 	}
 }
 
-func (slf *GoPipeline) setupConfig(dirpath string) {
-	if "" == dirpath {
-		dirpath = "conf.d"
-	}
-
+// 读取配置目录中的所有 .toml 文件，合并为根配置
+func loadConfig(dirpath string) conf.Map {
 	if fi, err := os.Stat(dirpath); nil != err || !fi.IsDir() {
 		withTag(log.Panic).Err(err).Msgf("Config path muse be a dir: %s", dirpath)
-		return
 	}
 
 	mergedTxt := new(bytes.Buffer)
@@ -214,18 +415,36 @@ func (slf *GoPipeline) setupConfig(dirpath string) {
 		}
 	}
 
+	rootConfig := conf.Map{}
 	if tree, err := toml.LoadBytes(mergedTxt.Bytes()); nil != err {
 		withTag(log.Panic).Err(err).Msg("Failed to decode toml config file")
 	} else {
-		slf.rootConfig = tree.ToMap()
+		rootConfig = tree.ToMap()
 	}
 
-	if len(slf.rootConfig) == 0 {
+	if len(rootConfig) == 0 {
 		withTag(log.Panic).Msg("Root config is EMPTY")
 	}
+	return rootConfig
+
+}
+
+func (slf *GoPipeline) setupConfig(dirpath string) {
+	if "" == dirpath {
+		dirpath = "conf.d"
+	}
+	slf.configDir = dirpath
+	slf.rootConfig = loadConfig(dirpath)
 
 	// Globals args
 	slf.globalsConfig = slf.rootConfig.MustMap("Globals")
+	if len(slf.globalsConfig) > 0 {
+		if err := conf.Map2Struct(slf.globalsConfig, &slf.routerConfig); nil != err {
+			withTag(log.Panic).Err(err).Msg("Failed to decode map to [Globals] config")
+		}
+	}
+	slf.deliverTimeout = DurationValue(slf.routerConfig.DeliverTimeout)
+	slf.drainTimeout = DurationOrDefault(slf.routerConfig.DrainTimeout, defaultDrainTimeout)
 	// Debugs config
 	debug := slf.rootConfig.MustMap("Debug")
 	if len(debug) > 0 {
@@ -233,13 +452,39 @@ func (slf *GoPipeline) setupConfig(dirpath string) {
 			withTag(log.Panic).Err(err).Msg("Failed to decode map to [Debug] config")
 		}
 	}
+	// Backpressure
+	slf.ingress = newIngress(slf.routerConfig, slf.fio, slf.debugConfig.VeryVerbose)
+	// Write-ahead log
+	slf.wal = newWriteAhead(slf.routerConfig, slf.fio)
+	// Ordering
+	slf.lanes = newOrderingLanes(slf.routerConfig)
+	// Priority
+	slf.priority = newPriorityLanes(slf.routerConfig)
+	// Admin
+	slf.setupAdmin()
 }
 
 func (slf *GoPipeline) startup() {
 	// 启动
-	resetFioCounter()
+	slf.fio.reset()
 	// Core Threads
 	slf.threads.Start()
+	if nil != slf.lanes {
+		slf.lanes.start()
+	}
+	if nil != slf.priority {
+		slf.priority.start(slf.threads.Post)
+	}
+	if nil != slf.ingress {
+		slf.ingress.start(slf.post)
+	}
+	if nil != slf.wal {
+		slf.wal.start(slf.replayWriteAhead)
+	}
+	// Output Queues
+	for ele := slf.outputRunners.Front(); ele != nil; ele = ele.Next() {
+		slf.startOutput(ele.Value.(*outputRunner))
+	}
 
 	// 组件最先启动
 	for ele := slf.plugins.Front(); ele != nil; ele = ele.Next() {
@@ -255,29 +500,95 @@ func (slf *GoPipeline) startup() {
 			go ele.Value.(*inputRunner).start(slf)
 		}, "InputRunner.Start")
 	}
+	// Admin
+	if nil != slf.admin {
+		slf.admin.start()
+	}
+	slf.running.Set(true)
 }
 
-func (slf *GoPipeline) shutdown() {
-	// 停止
-	closeSlot := func(slot VirtualSlot) {
-		if shutdown, ok := slot.(NeedShutdown); ok {
-			withTag(log.Info).Msgf("Shutdown slot: %s", slot.GetName())
-			shutdown.Shutdown()
-			withTag(log.Info).Msgf("Shutdown slot: %s [COMPLETED]", slot.GetName())
+// 启动Output的独立队列处理协程，以及批量处理
+func (slf *GoPipeline) startOutput(or *outputRunner) {
+	if nil != or.spool {
+		or.spool.start(func(pack *DataFrame) error {
+			return slf.replayOutput(or, pack)
+		}, func(pack *DataFrame, err error) {
+			slf.deadLetter(DeadLetterStageOutput, or.configKey, pack, err)
+		})
+	}
+	if nil != or.batch {
+		or.batch.start(func(packs []*DataFrame) {
+			slf.outputBatch0(or, packs)
+		})
+	}
+	if nil == or.queue {
+		return
+	}
+	or.queue.verbose = slf.debugConfig.VeryVerbose
+	or.queue.start(func(pack *DataFrame) {
+		if slf.stopped.Get() {
+			pack.fail(ErrPipelineStopped)
+			return
+		}
+		if !slf.checkExpired(pack, time.Now()) {
+			slf.output0(or, pack)
+		}
+	}, func(pack *DataFrame) {
+		if !or.spoolFrame(pack) {
+			pack.fail(ErrOutputOverflow)
 		}
+		releaseDataFrame(pack)
+	})
+}
+
+// 停止支持Shutdown接口的组件
+func closeSlot(slot VirtualSlot) {
+	if shutdown, ok := slot.(NeedShutdown); ok {
+		withTag(log.Info).Msgf("Shutdown slot: %s", slot.GetName())
+		shutdown.Shutdown()
+		withTag(log.Info).Msgf("Shutdown slot: %s [COMPLETED]", slot.GetName())
+	}
+}
+
+func (slf *GoPipeline) shutdown() {
+	slf.running.Set(false)
+	// 管理接口最先停止。其请求处理需要获取reloadMu
+	if nil != slf.admin {
+		slf.admin.close()
 	}
+	slf.reloadMu.Lock()
+	defer slf.reloadMu.Unlock()
+	// 停止
 	withTag(log.Info).Msg("Shutdown components...")
 	// Inputs
 	for ele := slf.inputRunners.Back(); ele != nil; ele = ele.Prev() {
-		closeSlot(ele.Value.(*inputRunner).input)
+		if r := ele.Value.(*inputRunner); !r.isDisabled() {
+			closeSlot(r.input)
+		}
+	}
+	// 等待处理中的消息完成
+	if dropped := slf.drain(); 0 < dropped {
+		withTag(log.Warn).Msgf("Drain TIMEOUT(%s), dropped frames: %d", slf.drainTimeout, dropped)
+	} else {
+		withTag(log.Info).Msg("Drain in-flight frames: [COMPLETED]")
 	}
 	// Filters
 	for ele := slf.filterRunners.Back(); ele != nil; ele = ele.Prev() {
-		closeSlot(ele.Value.(*filterRunner).filter)
+		if r := ele.Value.(*filterRunner); !r.isDisabled() {
+			closeSlot(r.filter)
+		}
 	}
 	// Outputs
 	for ele := slf.outputRunners.Back(); ele != nil; ele = ele.Prev() {
-		closeSlot(ele.Value.(*outputRunner).output)
+		if r := ele.Value.(*outputRunner); !r.isDisabled() {
+			if nil != r.batch {
+				r.batch.flush()
+			}
+			if nil != r.spool {
+				r.spool.close()
+			}
+			closeSlot(r.output)
+		}
 	}
 	withTag(log.Info).Msg("Shutdown components: [COMPLETED]")
 
@@ -289,25 +600,48 @@ func (slf *GoPipeline) shutdown() {
 	for ele := slf.plugins.Back(); ele != nil; ele = ele.Prev() {
 		ele.Value.(Plugin).Shutdown()
 	}
+	// Write-ahead log
+	if nil != slf.wal {
+		slf.wal.close()
+	}
+	if nil != slf.ingress {
+		slf.ingress.closeSpool()
+	}
 	// Core Threads
+	if nil != slf.lanes {
+		slf.lanes.close()
+	}
+	if nil != slf.priority {
+		slf.priority.close()
+	}
 	slf.threads.Shutdown()
 }
 
 // 启动消息路由
 func (slf *GoPipeline) StartRoute() {
 	// 接收系统中断信号
-	signal.Notify(slf.signals, os.Interrupt, os.Kill)
+	signal.Notify(slf.signals, os.Interrupt, os.Kill, syscall.SIGHUP)
 
 	slf.startup()
 	withTag(log.Info).Msg("STARTED")
 	defer withTag(log.Info).Msg("STOPPED")
 
-	// 等待系统中断信号
-	switch <-slf.signals {
+	// 等待系统中断信号。SIGHUP 重新加载配置后继续运行
+	sig := <-slf.signals
+	for syscall.SIGHUP == sig {
+		withTag(log.Info).Msg("Received system [HUP] signal")
+		if err := slf.Reload(); nil != err {
+			withTag(log.Error).Err(err).Msg("Reload config FAILED")
+		}
+		sig = <-slf.signals
+	}
+	switch sig {
 	case os.Kill:
 		withTag(log.Info).Msg("Received system [KILL] signal")
-		t := time.AfterFunc(time.Second*5, func() {
-			withTag(log.Info).Msg("Shutdown failed(3s timeout), FORCE KILL !!")
+		// 等待消息处理完成后，组件仍有5s时间停止
+		timeout := slf.drainTimeout + time.Second*5
+		t := time.AfterFunc(timeout, func() {
+			withTag(log.Info).Msgf("Shutdown failed(%s timeout), FORCE KILL !!", timeout)
 			os.Exit(-1)
 		})
 		slf.shutdown()
@@ -327,17 +661,83 @@ func (slf *GoPipeline) StopRouter() {
 
 // 接收到消息投递
 func (slf *GoPipeline) Deliver(pack *DataFrame) {
-	// 监控每个消息的处理阻塞情况，如果超时未完成处理，则输出警告信息。
-	// TODO 有没有更好的方法来处理？
-	t := time.AfterFunc(slf.debugDetectBlockTime, func() {
-		withTag(log.Warn).Msgf("Deliver BLOCKED: timeout > %s, sender: %s", slf.debugDetectBlockTime, pack.Sender())
-	})
+	slf.TryDeliver(pack)
+}
 
-	// 使用协程池来派发消息
-	slf.threads.Post(func() {
-		defer t.Stop()
+// 接收到消息投递，并返回投递结果。
+// 背压策略为block时，阻塞直到协程池接收消息；其它策略在入口队列已满时，按策略处理消息。
+func (slf *GoPipeline) TryDeliver(pack *DataFrame) error {
+	if nil != slf.ingress {
+		return slf.ingress.offer(pack)
+	}
+	slf.post(pack)
+	return nil
+}
+
+// 派发预写日志中的消息。消息经过入口队列，受背压控制：入口队列已满时，日志读取协程等待，暂停读取日志。
+func (slf *GoPipeline) replayWriteAhead(pack *DataFrame) {
+	if nil == slf.ingress {
+		slf.post(pack)
+		return
+	}
+	slf.ingress.put(pack, slf.wal.stop)
+}
+
+// 投递Input的消息。启用预写日志时，消息先写入日志，由日志读取协程派发。
+func (slf *GoPipeline) deliverInput(pack *DataFrame) error {
+	if nil != slf.wal {
+		return slf.wal.append(pack)
+	}
+	return slf.TryDeliver(pack)
+}
+
+// 将消息派发到协程池处理
+func (slf *GoPipeline) post(pack *DataFrame) {
+	posted := time.Now()
+	slf.inflight.Add(1)
+	task := func() {
+		defer slf.inflight.Add(-1)
+		if slf.stopped.Get() {
+			pack.fail(ErrPipelineStopped)
+			releaseDataFrame(pack)
+			return
+		}
+		// 监控每个消息在协程池中的等待时间，超时未被处理则输出警告信息。
+		now := time.Now()
+		if waits := now.Sub(posted); waits >= slf.debugDetectBlockTime {
+			withTag(log.Warn).Msgf("Deliver BLOCKED: waits %s, sender: %s", waits, pack.Sender())
+		}
+		if slf.checkExpired(pack, now) {
+			releaseDataFrame(pack)
+			return
+		}
 		slf.deliver0(pack)
-	})
+	}
+	// 配置了 ordering_key 的消息，由其Key对应的通道顺序处理
+	if nil != slf.lanes && slf.lanes.post(pack, task) {
+		return
+	}
+	// 配置了 priority_weights 时，按消息优先级排队，由调度协程派发到协程池
+	if nil != slf.priority {
+		slf.priority.post(pack, task)
+		return
+	}
+	// 使用协程池来派发消息
+	slf.threads.Post(task)
+}
+
+// 检查消息是否已超过截止时间。超时的消息被放弃处理并计数。
+func (slf *GoPipeline) checkExpired(pack *DataFrame, now time.Time) bool {
+	if !pack.isExpired(now) {
+		return false
+	}
+	slf.fio.increaseExpired()
+	pack.fail(context.DeadlineExceeded)
+	if slf.debugConfig.Verbose {
+		deadline, _ := pack.Deadline()
+		withTag(log.Debug).Msgf("Deliver EXPIRED: deadline %s, sender: %s", deadline, pack.Sender())
+	}
+	return true
 }
 
 func (slf *GoPipeline) deliver0(pack *DataFrame) {
@@ -347,6 +747,7 @@ func (slf *GoPipeline) deliver0(pack *DataFrame) {
 			if err, ok := r.(error); ok {
 				withTag(log.Error).Err(err).Msg("Error in core-goroutine")
 			}
+			pack.fail(fmt.Errorf("panic in core-goroutine: %v", r))
 
 			stackBuf := make([]byte, 1024*4)
 			stackBuf = stackBuf[:runtime.Stack(stackBuf, false)]
@@ -358,42 +759,14 @@ func (slf *GoPipeline) deliver0(pack *DataFrame) {
 		}
 	}()
 
+	snap := slf.acquireSnapshot()
+	defer snap.release()
+
 	// 缓存Filter处理结果，容量是Filter的数量+1
-	filteredOut := make([]*DataFrame, 1, slf.filterRunners.Len()+1)
+	filteredOut := make([]*DataFrame, 1, snap.filters+1)
 	// 第一个缓存是当前等待处理的消息，其它是Filter的输出结果
 	filteredOut[0] = pack
 
-	// filter
-	for ele := slf.filterRunners.Front(); ele != nil; ele = ele.Next() {
-		fr := ele.Value.(*filterRunner)
-		if !fr.checkAccept(pack) {
-			if slf.debugConfig.VeryVerbose {
-				withTag(log.Debug).Msgf("REJECTED [xx] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
-			}
-			continue
-		}
-
-		if slf.debugConfig.RoutingTrace {
-			withTag(log.Debug).Msgf("ACCEPTED [√√] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
-		}
-
-		s1 := time.Now()
-		// Filter返回输出消息。如果不是原样返回，则交给Output来处理。
-		if ret := fr.runFilter(s1, pack); nil != ret && pack != ret {
-			filteredOut = append(filteredOut, ret)
-		}
-		// 统计采样Filter处理消息的耗时
-		takes := time.Now().Sub(s1)
-		if takes >= slf.debugDetectBlockTime {
-			withTag(log.Warn).Msgf("Filter: <%s> BLOCKED, takes: %s", fr.filter.GetName(), takes)
-		}
-		// Counting & Samples
-		go func() {
-			increaseFilter()
-			sampleFilter(takes.Nanoseconds())
-		}()
-	}
-
 	// finally release all messages
 	defer func() {
 		for _, r := range filteredOut {
@@ -404,39 +777,391 @@ func (slf *GoPipeline) deliver0(pack *DataFrame) {
 		}
 	}()
 
+	// 声明了命名管道时，按管道路由消息。死信消息的Sender仍是原Input，按死信Topic路由，避免回到失败的管道。
+	if 0 < len(snap.pipelines) && !isDeadLetter(pack) {
+		for _, pl := range snap.pipelines {
+			if !pl.accept(pack) {
+				continue
+			}
+			if slf.debugConfig.RoutingTrace {
+				withTag(log.Debug).Msgf("ACCEPTED [√√] Pipeline: <%s> , sender: %s", pl.name, pack.Sender())
+			}
+			// 管道内的Filter按声明顺序链式处理消息
+			current := pack
+			for _, fr := range pl.filters {
+				if slf.checkExpired(current, time.Now()) {
+					return
+				}
+				if ret := slf.filter0(fr, current); DropDataFrame == ret {
+					current = nil
+					break
+				} else if nil != ret && current != ret {
+					ret.inheritAck(pack)
+					filteredOut = append(filteredOut, ret)
+					current = ret
+				}
+			}
+			if nil == current {
+				continue
+			}
+			jobs := make([]outputJob, 0, len(pl.outputs))
+			for _, or := range pl.outputs {
+				jobs = append(jobs, outputJob{output: or, pack: current})
+			}
+			slf.dispatchOutputs(snap.parallel, jobs)
+		}
+		return
+	}
+
+	// filter
+	route := snap.routes.lookup(pack.Topic())
+	var outputs []*DataFrame
+	if FilterModeChain == snap.mode {
+		// 链式：Filter返回的新消息作为下一个Filter的输入，只有最终结果交给Output处理。
+		current := pack
+		for _, entry := range route.filters {
+			if slf.checkExpired(current, time.Now()) {
+				return
+			}
+			if !slf.acceptFilter(entry, current) {
+				continue
+			}
+			if ret := slf.filter0(entry.filter, current); DropDataFrame == ret {
+				return
+			} else if nil != ret && current != ret {
+				ret.inheritAck(pack)
+				filteredOut = append(filteredOut, ret)
+				current = ret
+			}
+		}
+		outputs = []*DataFrame{current}
+	} else {
+		// 扇出：每个Filter都处理原始消息，返回的新消息与原始消息一起交给Output处理。
+		// Filter丢弃或者替换原始消息时，原始消息不再交给Output处理。
+		originOut := true
+		for _, entry := range route.filters {
+			if slf.checkExpired(pack, time.Now()) {
+				return
+			}
+			if !slf.acceptFilter(entry, pack) {
+				continue
+			}
+			if ret := slf.filter0(entry.filter, pack); DropDataFrame == ret {
+				originOut = false
+				break
+			} else if nil != ret && pack != ret {
+				ret.inheritAck(pack)
+				filteredOut = append(filteredOut, ret)
+				if ret.replacing {
+					originOut = false
+				}
+			}
+		}
+		if originOut {
+			outputs = filteredOut
+		} else {
+			outputs = filteredOut[1:]
+		}
+	}
+
 	// Output
-	for _, ret := range filteredOut {
+	jobs := make([]outputJob, 0, len(outputs))
+	for _, ret := range outputs {
 		if nil == ret {
 			break
 		}
-		for ele := slf.outputRunners.Front(); ele != nil; ele = ele.Next() {
-			or := ele.Value.(*outputRunner)
+		for _, entry := range snap.routes.lookup(ret.Topic()).outputs {
+			if slf.acceptOutput(entry, ret) {
+				jobs = append(jobs, outputJob{output: entry.output, pack: ret})
+			}
+		}
+	}
+	slf.dispatchOutputs(snap.parallel, jobs)
+}
 
-			if !or.checkAccept(ret) {
-				if slf.debugConfig.VeryVerbose {
-					withTag(log.Debug).Msgf("REJECTED [xx] Output: <%s>, sender: %s", or.output.GetName(), ret.Sender())
+// 等待Output处理的消息
+type outputJob struct {
+	output *outputRunner
+	pack   *DataFrame
+}
+
+// 将消息交给多个Output处理。parallel为true时，多个Output并行处理，等待全部完成后返回；
+// 每个并行任务持有消息引用，消息在所有Output完成后才被释放。
+func (slf *GoPipeline) dispatchOutputs(parallel bool, jobs []outputJob) {
+	// 超时的消息只计数一次，并跳过它的其它Output
+	var expired *DataFrame
+	isExpired := func(pack *DataFrame, now time.Time) bool {
+		if pack == expired {
+			return true
+		} else if slf.checkExpired(pack, now) {
+			expired = pack
+			return true
+		}
+		return false
+	}
+	if !parallel || 2 > len(jobs) {
+		for _, job := range jobs {
+			if !isExpired(job.pack, time.Now()) {
+				slf.dispatchOutput(job.output, job.pack)
+			}
+		}
+		return
+	}
+	s2 := time.Now()
+	takes := make([]time.Duration, len(jobs))
+	failed := NewAtomicInt64()
+	wg := new(sync.WaitGroup)
+	for i, job := range jobs {
+		if isExpired(job.pack, s2) {
+			continue
+		}
+		retainDataFrame(job.pack)
+		wg.Add(1)
+		go func(i int, job outputJob) {
+			defer wg.Done()
+			defer releaseDataFrame(job.pack)
+			// Output发生panic时，只标记此消息处理失败，不影响其它并行的Output
+			defer func() {
+				if r := recover(); nil != r {
+					failed.Add(1)
+					job.pack.fail(outputPanicError(job.output.configKey, r))
 				}
-				continue
+			}()
+			// 暂停或者启用独立队列的Output，不在此处理
+			if job.output.isPaused() || nil != job.output.queue {
+				slf.dispatchOutput(job.output, job.pack)
+				return
 			}
-
-			if slf.debugConfig.RoutingTrace {
-				withTag(log.Debug).Msgf("ACCEPTED [√√] Output: <%s> , sender: %s", or.output.GetName(), ret.Sender())
+			var err error
+			if takes[i], err = slf.output1(job.output, job.pack); nil != err {
+				failed.Add(1)
+			}
+		}(i, job)
+	}
+	wg.Wait()
+	// 汇总并行处理的耗时，只输出一次阻塞警告
+	if total := time.Now().Sub(s2); total >= slf.debugDetectBlockTime {
+		slowest := 0
+		for i := range takes {
+			if takes[i] > takes[slowest] {
+				slowest = i
 			}
+		}
+		withTag(log.Warn).Msgf("Outputs BLOCKED, parallel: %d, failed: %d, takes: %s, slowest: <%s> %s",
+			len(jobs), failed.Get(), total, jobs[slowest].output.output.GetName(), takes[slowest])
+	}
+}
+
+// 返回Filter是否接受此消息
+func (slf *GoPipeline) acceptFilter(entry routeEntry, pack *DataFrame) bool {
+	fr := entry.filter
+	if entry.needMatch && !fr.checkAccept(pack) {
+		if slf.debugConfig.VeryVerbose {
+			withTag(log.Debug).Msgf("REJECTED [xx] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
+		}
+		return false
+	}
+	if slf.debugConfig.RoutingTrace {
+		withTag(log.Debug).Msgf("ACCEPTED [√√] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
+	}
+	return true
+}
+
+// 返回Output是否接受此消息
+func (slf *GoPipeline) acceptOutput(entry routeEntry, pack *DataFrame) bool {
+	or := entry.output
+	if entry.needMatch && !or.checkAccept(pack) {
+		if slf.debugConfig.VeryVerbose {
+			withTag(log.Debug).Msgf("REJECTED [xx] Output: <%s>, sender: %s", or.output.GetName(), pack.Sender())
+		}
+		return false
+	}
+	if slf.debugConfig.RoutingTrace {
+		withTag(log.Debug).Msgf("ACCEPTED [√√] Output: <%s> , sender: %s", or.output.GetName(), pack.Sender())
+	}
+	return true
+}
+
+// 将消息交给Output处理。暂停的Output不处理消息，消息标记为处理失败，由Input决定是否重新投递。
+func (slf *GoPipeline) dispatchOutput(or *outputRunner, pack *DataFrame) {
+	if or.isPaused() {
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("PAUSED   [||] Output: <%s> , sender: %s", or.output.GetName(), pack.Sender())
+		}
+		pack.fail(ErrOutputPaused)
+		return
+	}
+	// 启用独立队列的Output，只将消息放入队列，由队列协程处理。
+	if nil != or.queue {
+		retainDataFrame(pack)
+		or.queue.offer(pack)
+	} else {
+		slf.output0(or, pack)
+	}
+}
+
+// 使用Filter处理消息，返回Filter的输出消息。Filter处理失败时，返回nil。
+// 暂停的Filter不处理消息，原样返回输入消息，交给后续的Filter和Output处理。
+func (slf *GoPipeline) filter0(fr *filterRunner, pack *DataFrame) *DataFrame {
+	if fr.isPaused() {
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("PAUSED   [||] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
+		}
+		return pack
+	}
+	s1 := time.Now()
+	ret, err := fr.runFilter(s1, pack)
+	if nil != err {
+		pack.fail(err)
+		withTag(log.Error).Err(err).Msgf("Filter: <%s> FAILED, sender: %s", fr.filter.GetName(), pack.Sender())
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("FAILED   [!!] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
+		}
+		slf.deadLetter(DeadLetterStageFilter, fr.configKey, pack, err)
+	} else if DropDataFrame == ret && slf.debugConfig.RoutingTrace {
+		withTag(log.Debug).Msgf("DROPPED  [--] Filter: <%s> , sender: %s", fr.filter.GetName(), pack.Sender())
+	}
+	// 统计采样Filter处理消息的耗时
+	takes := time.Now().Sub(s1)
+	if takes >= slf.debugDetectBlockTime {
+		withTag(log.Warn).Msgf("Filter: <%s> BLOCKED, takes: %s", fr.filter.GetName(), takes)
+	}
+	// Counting & Samples
+	go func() {
+		slf.fio.increaseFilter()
+		slf.samples.sampleFilter(takes.Nanoseconds())
+	}()
+	return ret
+}
+
+func (slf *GoPipeline) output0(or *outputRunner, pack *DataFrame) {
+	if takes, _ := slf.output1(or, pack); takes >= slf.debugDetectBlockTime {
+		withTag(log.Warn).Msgf("Output: <%s> BLOCKED, takes: %s", or.output.GetName(), takes)
+	}
+}
 
-			s2 := time.Now()
-			or.runOutput(ret)
-			// 统计采样Output处理消息的耗时
-			takes := time.Now().Sub(s2)
-			if takes >= slf.debugDetectBlockTime {
-				withTag(log.Warn).Msgf("Output: <%s> BLOCKED, takes: %s", or.output.GetName(), takes)
+// 处理消息，返回Output的处理耗时和错误。被限流、熔断或者加入批次的消息，耗时为0。
+func (slf *GoPipeline) output1(or *outputRunner, pack *DataFrame) (time.Duration, error) {
+	if nil != or.limiter && !or.limiter.take(pack) {
+		// 等待令牌时消息超时或者被取消，返回消息的上下文错误，不计为限流
+		if err := pack.Context().Err(); nil != err {
+			pack.fail(err)
+			return 0, err
+		}
+		or.counter.increaseThrottled()
+		slf.fio.increaseThrottled()
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("THROTTLE [--] Output: <%s> , sender: %s", or.output.GetName(), pack.Sender())
+		}
+		pack.fail(ErrOutputThrottled)
+		return 0, ErrOutputThrottled
+	}
+	// 磁盘暂存中存在未发送的消息时，新消息也写入暂存，以保持消息顺序
+	if nil != or.spool && or.spool.pending() && or.spoolFrame(pack) {
+		return 0, nil
+	}
+	// 熔断中的Output快速放弃消息，不输出错误日志
+	if nil != or.breaker && !or.breaker.allow() {
+		or.counter.increaseShortCircuited()
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("BROKEN   [--] Output: <%s> , sender: %s", or.output.GetName(), pack.Sender())
+		}
+		if or.spoolFrame(pack) {
+			return 0, nil
+		}
+		if or.breaker.deadLetter {
+			slf.deadLetter(DeadLetterStageOutput, or.configKey, pack, ErrCircuitOpen)
+		}
+		pack.fail(ErrCircuitOpen)
+		return 0, ErrCircuitOpen
+	}
+	// 批量处理的Output，消息加入批次后由批次统一处理
+	if nil != or.batch {
+		or.batch.add(pack)
+		return 0, nil
+	}
+	s2 := time.Now()
+	err := or.runOutput(pack)
+	if nil != or.breaker {
+		or.breaker.done(err)
+	}
+	if nil != err {
+		withTag(log.Error).Err(err).Msgf("Output: <%s> FAILED, sender: %s", or.output.GetName(), pack.Sender())
+		if slf.debugConfig.RoutingTrace {
+			withTag(log.Debug).Msgf("FAILED   [!!] Output: <%s> , sender: %s", or.output.GetName(), pack.Sender())
+		}
+		if or.spoolFrame(pack) {
+			err = nil
+		} else {
+			pack.fail(err)
+			slf.deadLetter(DeadLetterStageOutput, or.configKey, pack, err)
+		}
+	}
+	// 统计采样Output处理消息的耗时
+	takes := time.Now().Sub(s2)
+	// Counting & Samples
+	go func() {
+		slf.fio.increaseOutbounds()
+		slf.samples.sampleOutbounds(takes.Nanoseconds())
+	}()
+	return takes, err
+}
+
+// 重新发送磁盘暂存的消息。熔断中的Output不发送，等待下次重试。
+func (slf *GoPipeline) replayOutput(or *outputRunner, pack *DataFrame) error {
+	if nil != or.breaker && !or.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	var err error
+	if nil != or.batch {
+		err = or.runOutputBatch([]*DataFrame{pack})
+	} else {
+		err = or.runOutput(pack)
+	}
+	if nil != or.breaker {
+		or.breaker.done(err)
+	}
+	if nil != err {
+		if slf.debugConfig.Verbose {
+			withTag(log.Debug).Err(err).Msgf("Output: <%s> replay spooled frame FAILED, sender: %s", or.output.GetName(), pack.Sender())
+		}
+		return err
+	}
+	go slf.fio.increaseOutbounds()
+	return nil
+}
+
+// 批量处理消息。批次处理失败时，批次中每个消息都投递到死信Topic。
+func (slf *GoPipeline) outputBatch0(or *outputRunner, packs []*DataFrame) {
+	s2 := time.Now()
+	err := or.runOutputBatch(packs)
+	if nil != or.breaker {
+		for range packs {
+			or.breaker.done(err)
+		}
+	}
+	if nil != err {
+		withTag(log.Error).Err(err).Msgf("Output: <%s> FAILED, batch: %d", or.output.GetName(), len(packs))
+		for _, pack := range packs {
+			if or.spoolFrame(pack) {
+				continue
 			}
-			// Counting & Samples
-			go func() {
-				increaseOutbounds()
-				sampleOutbounds(takes.Nanoseconds())
-			}()
+			pack.fail(err)
+			slf.deadLetter(DeadLetterStageOutput, or.configKey, pack, err)
 		}
 	}
+	takes := time.Now().Sub(s2)
+	if takes >= slf.debugDetectBlockTime {
+		withTag(log.Warn).Msgf("Output: <%s> BLOCKED, batch: %d, takes: %s", or.output.GetName(), len(packs), takes)
+	}
+	// Counting & Samples
+	size := len(packs)
+	go func() {
+		for i := 0; i < size; i++ {
+			slf.fio.increaseOutbounds()
+		}
+		slf.samples.sampleOutbounds(takes.Nanoseconds() / int64(size))
+	}()
 }
 
 // 创建Router，指定处理消息的协程最大数量
@@ -462,6 +1187,17 @@ func newRouter(maxGoNum int) *GoPipeline {
 
 		threads: goes.NewGoesPool(maxGoNum, maxGoNum),
 		signals: make(chan os.Signal, 1),
+
+		snapshot: new(atomic.Value),
+		reloadMu: new(sync.Mutex),
+		inflight: NewAtomicInt64(),
+		running:  NewAtomicBoolean(),
+		stopped:  NewAtomicBoolean(),
+
+		fio:               new(FioCounter),
+		samples:           newDataFrameSamples(),
+		queueCounters:     new(sync.Map),
+		componentCounters: new(sync.Map),
 	}
 }
 
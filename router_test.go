@@ -0,0 +1,411 @@
+package gopl
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+// 在消息Header的 steps 字段追加自己的名称
+type testStepFilter struct {
+	AbcSlot
+}
+
+func (slf *testStepFilter) Filter(pack *DataFrame) *DataFrame {
+	out := NewDataFrame()
+	out.SetHeader("steps", pack.HeaderOrDefault("steps", "")+"/"+slf.GetName())
+	return out
+}
+
+// 记录收到的消息的 steps 字段
+type testRecordOutput struct {
+	AbcSlot
+	mu      sync.Mutex
+	records []string
+}
+
+func (slf *testRecordOutput) Output(pack *DataFrame) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.records = append(slf.records, pack.HeaderOrDefault("steps", ""))
+}
+
+func newTestRouter(mode string, filters ...string) (*GoPipeline, *testRecordOutput) {
+	router := newRouter(1)
+	router.routerConfig.FilterMode = mode
+	for _, name := range filters {
+		filter := new(testStepFilter)
+		filter.SetName(name)
+		router.filterRunners.PushBack(newFilterRunner(filter, new(AnyMatcher), &ComponentConfig{}, name))
+	}
+	router.routerConfig.FilterChain = filters
+	router.sortFilterRunners()
+
+	output := new(testRecordOutput)
+	output.SetName("TestRecordOutput")
+	router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "TestRecordOutput"))
+	router.buildRouteTable()
+	return router, output
+}
+
+func TestRouter_FilterChain(t *testing.T) {
+	router, output := newTestRouter(FilterModeChain, "decode", "enrich", "mask")
+	router.deliver0(NewDataFrame())
+	if 1 != len(output.records) || "/decode/enrich/mask" != output.records[0] {
+		t.Fatalf("Chain result not match, was: %v", output.records)
+	}
+}
+
+func TestRouter_FilterFanout(t *testing.T) {
+	router, output := newTestRouter(FilterModeFanout, "decode", "enrich")
+	router.deliver0(NewDataFrame())
+	if 3 != len(output.records) || "" != output.records[0] ||
+		"/decode" != output.records[1] || "/enrich" != output.records[2] {
+		t.Fatalf("Fanout result not match, was: %v", output.records)
+	}
+}
+
+func TestRouter_Pipelines(t *testing.T) {
+	router, _ := newTestRouter(FilterModeFanout, "decode", "enrich", "mask")
+	other := new(testRecordOutput)
+	other.SetName("OtherOutput")
+	router.outputRunners.PushBack(newOutputRunner(other, new(AnyMatcher), &ComponentConfig{}, "OtherOutput"))
+	router.inputRunners.PushBack(newInputRunner(nil, nil, &ComponentConfig{}, "InputA", 0))
+	router.inputRunners.PushBack(newInputRunner(nil, nil, &ComponentConfig{}, "InputB", 0))
+
+	router.rootConfig[pipelineConfigKey] = []interface{}{
+		map[string]interface{}{
+			"name":    "a",
+			"inputs":  []interface{}{"InputA"},
+			"filters": []interface{}{"mask", "decode"},
+			"outputs": []interface{}{"TestRecordOutput"},
+		},
+		map[string]interface{}{
+			"name":    "b",
+			"inputs":  []interface{}{"InputB"},
+			"outputs": []interface{}{"OtherOutput"},
+		},
+	}
+	router.setupPipelines()
+	router.buildRouteTable()
+	if 2 != len(router.pipelines) {
+		t.Fatalf("Pipelines not match, was: %d", len(router.pipelines))
+	}
+
+	packA := NewDataFrame()
+	packA.addTrace("InputA", 0)
+	router.deliver0(packA)
+	packB := NewDataFrame()
+	packB.addTrace("InputB", 0)
+	router.deliver0(packB)
+
+	first := router.outputRunners.Front().Value.(*outputRunner).output.(*testRecordOutput)
+	if 1 != len(first.records) || "/mask/decode" != first.records[0] {
+		t.Fatalf("Pipeline a not match, was: %v", first.records)
+	}
+	if 1 != len(other.records) || "" != other.records[0] {
+		t.Fatalf("Pipeline b not match, was: %v", other.records)
+	}
+}
+
+// 根据消息Header的 action 字段，丢弃或替换消息
+type testActionFilter struct {
+	AbcSlot
+}
+
+func (slf *testActionFilter) Filter(pack *DataFrame) *DataFrame {
+	switch pack.HeaderOrDefault("action", "") {
+	case "drop":
+		return DropDataFrame
+	case "replace":
+		out := NewDataFrame()
+		out.SetHeader("steps", "/replaced")
+		return ReplaceDataFrame(out)
+	default:
+		return nil
+	}
+}
+
+func TestRouter_FilterDropAndReplace(t *testing.T) {
+	for _, mode := range []string{FilterModeFanout, FilterModeChain} {
+		router, output := newTestRouter(mode)
+		filter := new(testActionFilter)
+		filter.SetName("action")
+		router.filterRunners.PushBack(newFilterRunner(filter, new(AnyMatcher), &ComponentConfig{}, "action"))
+		router.buildRouteTable()
+
+		drop := NewDataFrame()
+		drop.SetHeader("action", "drop")
+		router.deliver0(drop)
+		if 0 != len(output.records) {
+			t.Fatalf("[%s] Dropped frame should not output, was: %v", mode, output.records)
+		}
+
+		replace := NewDataFrame()
+		replace.SetHeader("action", "replace")
+		router.deliver0(replace)
+		if 1 != len(output.records) || "/replaced" != output.records[0] {
+			t.Fatalf("[%s] Replaced frame not match, was: %v", mode, output.records)
+		}
+	}
+}
+
+func newTestConfigDir(t *testing.T, config string) string {
+	dir, err := ioutil.TempDir("", "gopl-router")
+	if nil != err {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0644); nil != err {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestNew_Isolated(t *testing.T) {
+	dirA := newTestConfigDir(t, "[Globals]\n  name = \"A\"\n[Debug]\n  routing_trace = true\n[testRecordOutput]\n  topic = \"/a\"\n")
+	defer os.RemoveAll(dirA)
+	dirB := newTestConfigDir(t, "[Globals]\n  name = \"B\"\n[Output2]\n  component = \"testRecordOutput\"\n  topic = \"/b\"\n")
+	defer os.RemoveAll(dirB)
+
+	routerA := New(Options{MaxGoroutines: 1})
+	routerA.Prepare(func(r *GoPipeline) {
+		r.AutoRegister(new(testRecordOutput))
+	})
+	routerA.Setup(dirA)
+	routerA.Init()
+
+	routerB := New(Options{MaxGoroutines: 1})
+	routerB.Prepare()
+	routerB.Setup(dirB)
+	// B实例未注册 testRecordOutput 组件
+	if 0 != routerB.outputRunners.Len() {
+		t.Fatal("Registry should be instance scoped")
+	}
+
+	if "A" != routerA.Globals().MustString("name") || "B" != routerB.Globals().MustString("name") {
+		t.Fatal("Globals should be instance scoped")
+	}
+	if !routerA.Debugs().RoutingTrace || routerB.Debugs().RoutingTrace {
+		t.Fatal("Debugs should be instance scoped")
+	}
+
+	or := routerA.outputRunners.Front().Value.(*outputRunner)
+	if or.output.(NeedPipeline).Pipeline() != routerA {
+		t.Fatal("Component should own its pipeline")
+	}
+	if !or.matcher.(*DefaultURLMatcher).trace {
+		t.Fatal("Matcher trace should follow pipeline debug config")
+	}
+
+	pack := NewDataFrame()
+	pack.addTrace("TestInput", 0)
+	pack.setTopic("/a")
+	routerA.deliver0(pack)
+	if 1 != len(routerA.ComponentCounters()) || 1 != routerA.ComponentCounters()[0].Handled() {
+		t.Fatal("Component counters of A not match")
+	}
+	if 0 != len(routerB.ComponentCounters()) {
+		t.Fatal("Component counters should be instance scoped")
+	}
+}
+
+// 阻塞处理消息，直到 release 被关闭
+type testBlockOutput struct {
+	AbcSlot
+	release chan struct{}
+}
+
+func (slf *testBlockOutput) Output(pack *DataFrame) {
+	<-slf.release
+}
+
+func TestRouter_Drain(t *testing.T) {
+	router, output := newTestRouter(FilterModeFanout)
+	router.drainTimeout = time.Second
+	router.threads.Start()
+	defer router.threads.Shutdown()
+	for i := 0; i < 10; i++ {
+		router.post(NewDataFrame())
+	}
+	if dropped := router.drain(); 0 != dropped {
+		t.Fatalf("Should drain all frames, dropped: %d", dropped)
+	}
+	if 10 != len(output.records) {
+		t.Fatalf("Output records not match, was: %d", len(output.records))
+	}
+}
+
+func TestRouter_DrainTimeout(t *testing.T) {
+	router := newRouter(1)
+	output := &testBlockOutput{release: make(chan struct{})}
+	output.SetName("TestBlockOutput")
+	router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "TestBlockOutput"))
+	router.buildRouteTable()
+	router.drainTimeout = time.Millisecond * 100
+	router.threads.Start()
+	defer router.threads.Shutdown()
+	defer close(output.release)
+
+	router.post(NewDataFrame())
+	if dropped := router.drain(); 1 != dropped {
+		t.Fatalf("Should drop blocked frame, dropped: %d", dropped)
+	}
+	if !router.stopped.Get() {
+		t.Fatal("Router should be stopped after drain")
+	}
+}
+
+// 记录是否被停止
+type testShutdownOutput struct {
+	testRecordOutput
+	shutdown bool
+}
+
+func (slf *testShutdownOutput) Shutdown() {
+	slf.shutdown = true
+}
+
+func TestRouter_Reload(t *testing.T) {
+	config := "[OutputA]\n  component = \"testShutdownOutput\"\n  topic = \"/a\"\n" +
+		"[OutputB]\n  component = \"testShutdownOutput\"\n  topic = \"/b\"\n"
+	dir := newTestConfigDir(t, config)
+	defer os.RemoveAll(dir)
+
+	router := New(Options{MaxGoroutines: 1})
+	router.Prepare(func(r *GoPipeline) {
+		r.AutoRegister(new(testShutdownOutput))
+	})
+	router.Setup(dir)
+	router.Init()
+	outputA := router.findRunner("OutputA").(*outputRunner)
+	outputB := router.findRunner("OutputB").(*outputRunner)
+
+	// OutputA 不变，OutputB 修改Topic，新增 OutputC
+	config = "[Globals]\n  filter_mode = \"chain\"\n" +
+		"[OutputA]\n  component = \"testShutdownOutput\"\n  topic = \"/a\"\n" +
+		"[OutputB]\n  component = \"testShutdownOutput\"\n  topic = \"/c\"\n" +
+		"[OutputC]\n  component = \"testShutdownOutput\"\n  topic = \"/c\"\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte(config), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := router.Reload(); nil != err {
+		t.Fatal(err)
+	}
+	if outputA != router.findRunner("OutputA") || outputA.output.(*testShutdownOutput).shutdown {
+		t.Fatal("Unchanged output should keep running")
+	}
+	if outputB == router.findRunner("OutputB") || !outputB.output.(*testShutdownOutput).shutdown {
+		t.Fatal("Changed output should be restarted")
+	}
+	if !outputB.isDisabled() {
+		t.Fatal("Closed output should be disabled")
+	}
+	if FilterModeChain != router.snapshot.Load().(*routeSnapshot).mode {
+		t.Fatal("Filter mode should be reloaded")
+	}
+
+	pack := NewDataFrame()
+	pack.addTrace("TestInput", 0)
+	pack.setTopic("/c")
+	router.deliver0(pack)
+	for _, key := range []string{"OutputB", "OutputC"} {
+		output := router.findRunner(key).(*outputRunner).output.(*testShutdownOutput)
+		if 1 != len(output.records) {
+			t.Fatalf("Output: %s records not match, was: %d", key, len(output.records))
+		}
+	}
+
+	// 删除 OutputC；配置错误时保持当前配置
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.toml"), []byte("[Globals]\n  filter_mode = \"bad\"\n"), 0644); nil != err {
+		t.Fatal(err)
+	}
+	if err := router.Reload(); nil == err {
+		t.Fatal("Invalid config should fail")
+	}
+	if nil == router.findRunner("OutputC") {
+		t.Fatal("Should keep current config after failed reload")
+	}
+}
+
+// 处理消息耗时固定，并记录消息Body
+type testSleepOutput struct {
+	AbcSlot
+	sleep  time.Duration
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (slf *testSleepOutput) Output(pack *DataFrame) {
+	time.Sleep(slf.sleep)
+	body, _ := pack.ReadBytes()
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	slf.bodies = append(slf.bodies, string(body))
+}
+
+func TestRouter_ParallelOutputs(t *testing.T) {
+	router := newRouter(1)
+	router.routerConfig.ParallelOutputs = true
+	outputs := make([]*testSleepOutput, 3)
+	for i := range outputs {
+		outputs[i] = &testSleepOutput{sleep: time.Millisecond * 50}
+		outputs[i].SetName(fmt.Sprintf("SleepOutput%d", i))
+		router.outputRunners.PushBack(newOutputRunner(outputs[i], new(AnyMatcher), &ComponentConfig{}, outputs[i].GetName()))
+	}
+	router.buildRouteTable()
+
+	pack := NewDataFrame()
+	pack.SetBody(strings.NewReader("payload"))
+	start := time.Now()
+	router.deliver0(pack)
+	if takes := time.Now().Sub(start); takes >= time.Millisecond*120 {
+		t.Fatalf("Outputs should run in parallel, takes: %s", takes)
+	}
+	for _, output := range outputs {
+		if 1 != len(output.bodies) || "payload" != output.bodies[0] {
+			t.Fatalf("Output %s records not match, was: %v", output.GetName(), output.bodies)
+		}
+	}
+}
+
+// 处理消息时panic
+type testPanicOutput struct {
+	AbcSlot
+}
+
+func (slf *testPanicOutput) Output(pack *DataFrame) {
+	panic("output crashed")
+}
+
+func TestRouter_ParallelOutputsPanic(t *testing.T) {
+	router := newRouter(1)
+	router.routerConfig.ParallelOutputs = true
+	crashed := new(testPanicOutput)
+	crashed.SetName("PanicOutput")
+	router.outputRunners.PushBack(newOutputRunner(crashed, new(AnyMatcher), &ComponentConfig{}, "PanicOutput"))
+	output := &testSleepOutput{}
+	output.SetName("SleepOutput")
+	router.outputRunners.PushBack(newOutputRunner(output, new(AnyMatcher), &ComponentConfig{}, "SleepOutput"))
+	router.buildRouteTable()
+
+	recorder := new(testAckRecorder)
+	pack := NewDataFrame()
+	pack.SetBody(strings.NewReader("payload"))
+	pack.SetAckHandler(recorder.handler)
+	router.deliver0(pack)
+	if 1 != len(output.bodies) {
+		t.Fatalf("Other output should handle frame, was: %v", output.bodies)
+	}
+	if acks := recorder.results(); 1 != len(acks) || nil == acks[0] {
+		t.Fatalf("Panicked output should fail frame, was: %v", acks)
+	}
+}
@@ -0,0 +1,399 @@
+package spool
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 基于分段文件的磁盘消息队列。
+//   - 消息按顺序追加到分段文件中，每个消息分配递增的Offset；
+//   - 单个消费者按顺序读取消息，通过 Commit 确认已处理的Offset；
+//   - 已确认的分段文件会被删除，确认位置保存在 checkpoint 文件中，重新打开队列时从确认位置恢复读取；
+//
+
+const (
+	segmentSuffix  = ".seg"
+	checkpointName = "checkpoint"
+	recordHeadSize = 8 // 4字节长度 + 4字节CRC32
+
+	DefaultSegmentBytes = 64 * 1024 * 1024
+)
+
+var (
+	ErrFull   = errors.New("spool: queue is full")
+	ErrClosed = errors.New("spool: queue is closed")
+)
+
+// 队列配置选项
+type Options struct {
+	SegmentBytes int64 // 单个分段文件的最大字节数，默认64MB
+	MaxBytes     int64 // 队列文件的最大字节数，超过时 Append 返回 ErrFull。0表示不限制
+	SyncWrite    bool  // 每次追加消息后，是否同步写入磁盘
+}
+
+type segment struct {
+	base  uint64 // 分段第一个消息的Offset
+	count uint64 // 分段的消息数量
+	size  int64  // 分段文件字节数
+	path  string
+}
+
+type Queue struct {
+	dir  string
+	opts Options
+
+	mu        *sync.Mutex
+	segments  []*segment
+	writer    *os.File
+	nextOff   uint64 // 下一个追加消息的Offset
+	committed uint64 // 小于此Offset的消息已被确认
+	size      int64
+	closed    bool
+
+	readOff  uint64   // 下一个读取消息的Offset
+	readSeg  *segment // 当前读取的分段
+	readFile *os.File
+	readPos  int64
+
+	signal chan struct{}
+}
+
+// Open 打开或者创建指定目录的队列，并从上次确认的位置恢复读取。
+func Open(dir string, opts Options) (*Queue, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0755); nil != err {
+		return nil, errors.WithMessage(err, "spool: create dir")
+	}
+	queue := &Queue{
+		dir:      dir,
+		opts:     opts,
+		mu:       new(sync.Mutex),
+		segments: make([]*segment, 0),
+		signal:   make(chan struct{}, 1),
+	}
+	if err := queue.recover(); nil != err {
+		return nil, err
+	}
+	return queue, nil
+}
+
+// 扫描分段文件，恢复Offset和确认位置
+func (slf *Queue) recover() error {
+	files, err := ioutil.ReadDir(slf.dir)
+	if nil != err {
+		return errors.WithMessage(err, "spool: list dir")
+	}
+	bases := make([]uint64, 0)
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		if base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64); nil == err {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool {
+		return bases[i] < bases[j]
+	})
+	for i, base := range bases {
+		seg := &segment{base: base, path: slf.segmentPath(base)}
+		last := i == len(bases)-1
+		if err := scanSegment(seg, last); nil != err {
+			return err
+		}
+		slf.segments = append(slf.segments, seg)
+		slf.size += seg.size
+		slf.nextOff = seg.base + seg.count
+	}
+
+	if 0 < len(slf.segments) {
+		slf.committed = slf.segments[0].base
+	}
+	if bs, err := ioutil.ReadFile(filepath.Join(slf.dir, checkpointName)); nil == err && 8 == len(bs) {
+		if off := binary.BigEndian.Uint64(bs); off > slf.committed {
+			slf.committed = off
+		}
+	}
+	if slf.committed > slf.nextOff {
+		slf.committed = slf.nextOff
+	}
+	slf.readOff = slf.committed
+	slf.removeCommitted()
+
+	if 0 == len(slf.segments) {
+		return slf.roll()
+	}
+	tail := slf.segments[len(slf.segments)-1]
+	writer, err := os.OpenFile(tail.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if nil != err {
+		return errors.WithMessage(err, "spool: open segment")
+	}
+	slf.writer = writer
+	return nil
+}
+
+// 扫描分段文件的消息数量。最后一个分段文件中不完整的消息将被截断。
+func scanSegment(seg *segment, truncate bool) error {
+	file, err := os.Open(seg.path)
+	if nil != err {
+		return errors.WithMessage(err, "spool: open segment")
+	}
+	defer file.Close()
+	pos := int64(0)
+	for {
+		data, err := readRecord(file, pos)
+		if nil != err {
+			break
+		}
+		pos += int64(recordHeadSize + len(data))
+		seg.count++
+	}
+	seg.size = pos
+	if truncate {
+		if fi, err := file.Stat(); nil == err && fi.Size() > pos {
+			return os.Truncate(seg.path, pos)
+		}
+	}
+	return nil
+}
+
+func readRecord(file *os.File, pos int64) ([]byte, error) {
+	head := make([]byte, recordHeadSize)
+	if _, err := file.ReadAt(head, pos); nil != err {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(head[:4])
+	data := make([]byte, length)
+	if _, err := file.ReadAt(data, pos+recordHeadSize); nil != err {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(head[4:]) {
+		return nil, errors.New("spool: checksum not match")
+	}
+	return data, nil
+}
+
+func (slf *Queue) segmentPath(base uint64) string {
+	return filepath.Join(slf.dir, fmt.Sprintf("%020d%s", base, segmentSuffix))
+}
+
+// 创建新的分段文件
+func (slf *Queue) roll() error {
+	if nil != slf.writer {
+		slf.writer.Close()
+	}
+	seg := &segment{base: slf.nextOff, path: slf.segmentPath(slf.nextOff)}
+	writer, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if nil != err {
+		return errors.WithMessage(err, "spool: create segment")
+	}
+	slf.writer = writer
+	slf.segments = append(slf.segments, seg)
+	return nil
+}
+
+// Append 追加消息，返回消息的Offset
+func (slf *Queue) Append(data []byte) (uint64, error) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.closed {
+		return 0, ErrClosed
+	}
+	recordSize := int64(recordHeadSize + len(data))
+	tail := slf.segments[len(slf.segments)-1]
+	if 0 < slf.opts.MaxBytes && slf.size+recordSize > slf.opts.MaxBytes {
+		// 最后一个分段已全部确认时，创建新分段以释放空间
+		if 0 == tail.count || slf.committed < slf.nextOff {
+			return 0, ErrFull
+		}
+		if err := slf.roll(); nil != err {
+			return 0, err
+		}
+		slf.removeCommitted()
+		tail = slf.segments[len(slf.segments)-1]
+		if slf.size+recordSize > slf.opts.MaxBytes {
+			return 0, ErrFull
+		}
+	}
+	if 0 < tail.count && tail.size+recordSize > slf.opts.SegmentBytes {
+		if err := slf.roll(); nil != err {
+			return 0, err
+		}
+		tail = slf.segments[len(slf.segments)-1]
+	}
+
+	buf := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[recordHeadSize:], data)
+	if _, err := slf.writer.Write(buf); nil != err {
+		return 0, errors.WithMessage(err, "spool: write segment")
+	}
+	if slf.opts.SyncWrite {
+		if err := slf.writer.Sync(); nil != err {
+			return 0, errors.WithMessage(err, "spool: sync segment")
+		}
+	}
+	offset := slf.nextOff
+	tail.count++
+	tail.size += recordSize
+	slf.size += recordSize
+	slf.nextOff++
+
+	select {
+	case slf.signal <- struct{}{}:
+	default:
+	}
+	return offset, nil
+}
+
+// Next 按顺序读取下一个消息。没有可读取的消息时，返回 io.EOF
+func (slf *Queue) Next() (uint64, []byte, error) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.closed {
+		return 0, nil, ErrClosed
+	}
+	if slf.readOff >= slf.nextOff {
+		return 0, nil, io.EOF
+	}
+	if nil == slf.readSeg || slf.readOff >= slf.readSeg.base+slf.readSeg.count {
+		if err := slf.seekRead(slf.readOff); nil != err {
+			return 0, nil, err
+		}
+	}
+	data, err := readRecord(slf.readFile, slf.readPos)
+	if nil != err {
+		return 0, nil, errors.WithMessage(err, "spool: read segment")
+	}
+	offset := slf.readOff
+	slf.readPos += int64(recordHeadSize + len(data))
+	slf.readOff++
+	return offset, data, nil
+}
+
+// 定位到指定Offset所在的分段文件
+func (slf *Queue) seekRead(offset uint64) error {
+	if nil != slf.readFile {
+		slf.readFile.Close()
+		slf.readFile = nil
+		slf.readSeg = nil
+	}
+	for _, seg := range slf.segments {
+		if offset < seg.base || offset >= seg.base+seg.count {
+			continue
+		}
+		file, err := os.Open(seg.path)
+		if nil != err {
+			return errors.WithMessage(err, "spool: open segment")
+		}
+		pos := int64(0)
+		for i := seg.base; i < offset; i++ {
+			head := make([]byte, recordHeadSize)
+			if _, err := file.ReadAt(head, pos); nil != err {
+				file.Close()
+				return errors.WithMessage(err, "spool: seek segment")
+			}
+			pos += int64(recordHeadSize + binary.BigEndian.Uint32(head[:4]))
+		}
+		slf.readSeg = seg
+		slf.readFile = file
+		slf.readPos = pos
+		return nil
+	}
+	return errors.Errorf("spool: offset %d not found", offset)
+}
+
+// Commit 确认指定Offset及之前的消息已处理完成。已全部确认的分段文件将被删除。
+func (slf *Queue) Commit(offset uint64) error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.closed {
+		return ErrClosed
+	}
+	if offset+1 <= slf.committed {
+		return nil
+	}
+	slf.committed = offset + 1
+	if slf.committed > slf.nextOff {
+		slf.committed = slf.nextOff
+	}
+	slf.removeCommitted()
+	return slf.writeCheckpoint()
+}
+
+func (slf *Queue) writeCheckpoint() error {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, slf.committed)
+	tmp := filepath.Join(slf.dir, checkpointName+".tmp")
+	if err := ioutil.WriteFile(tmp, buf, 0644); nil != err {
+		return errors.WithMessage(err, "spool: write checkpoint")
+	}
+	return os.Rename(tmp, filepath.Join(slf.dir, checkpointName))
+}
+
+// 删除已全部确认的分段文件，保留最后一个分段用于写入
+func (slf *Queue) removeCommitted() {
+	for 1 < len(slf.segments) {
+		seg := slf.segments[0]
+		if seg.base+seg.count > slf.committed {
+			break
+		}
+		if slf.readSeg == seg {
+			slf.readFile.Close()
+			slf.readFile = nil
+			slf.readSeg = nil
+		}
+		os.Remove(seg.path)
+		slf.size -= seg.size
+		slf.segments = slf.segments[1:]
+	}
+}
+
+// Signal 返回新消息通知通道。追加消息时，通道可读。
+func (slf *Queue) Signal() <-chan struct{} {
+	return slf.signal
+}
+
+// Len 返回未确认的消息数量
+func (slf *Queue) Len() uint64 {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	return slf.nextOff - slf.committed
+}
+
+// Size 返回队列文件的字节数
+func (slf *Queue) Size() int64 {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	return slf.size
+}
+
+// Close 关闭队列文件
+func (slf *Queue) Close() error {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.closed {
+		return nil
+	}
+	slf.closed = true
+	if nil != slf.readFile {
+		slf.readFile.Close()
+	}
+	return slf.writer.Close()
+}
@@ -0,0 +1,104 @@
+package spool
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func newTestQueue(t *testing.T, opts Options) (*Queue, string) {
+	dir, err := ioutil.TempDir("", "gopl-spool")
+	if nil != err {
+		t.Fatal(err)
+	}
+	queue, err := Open(dir, opts)
+	if nil != err {
+		t.Fatal(err)
+	}
+	return queue, dir
+}
+
+func TestQueue_AppendNextCommit(t *testing.T) {
+	queue, dir := newTestQueue(t, Options{SegmentBytes: 64})
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		if off, err := queue.Append([]byte(fmt.Sprintf("message-%d", i))); nil != err || uint64(i) != off {
+			t.Fatalf("Append failed, offset: %d, err: %s", off, err)
+		}
+	}
+	if 1 >= len(queue.segments) {
+		t.Fatal("Should roll segments")
+	}
+	for i := 0; i < 10; i++ {
+		off, data, err := queue.Next()
+		if nil != err || fmt.Sprintf("message-%d", i) != string(data) || uint64(i) != off {
+			t.Fatalf("Next not match, offset: %d, data: %s, err: %s", off, data, err)
+		}
+	}
+	if _, _, err := queue.Next(); io.EOF != err {
+		t.Fatalf("Should be EOF, was: %s", err)
+	}
+	if err := queue.Commit(9); nil != err {
+		t.Fatal(err)
+	}
+	if 0 != queue.Len() || 1 != len(queue.segments) {
+		t.Fatalf("Committed segments should be removed, len: %d, segments: %d", queue.Len(), len(queue.segments))
+	}
+	queue.Close()
+}
+
+func TestQueue_Recover(t *testing.T) {
+	queue, dir := newTestQueue(t, Options{SegmentBytes: 64})
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 6; i++ {
+		queue.Append([]byte(fmt.Sprintf("message-%d", i)))
+	}
+	queue.Next()
+	queue.Next()
+	queue.Next()
+	queue.Commit(2)
+	queue.Close()
+
+	reopen, err := Open(dir, Options{SegmentBytes: 64})
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer reopen.Close()
+	if 3 != reopen.Len() {
+		t.Fatalf("Uncommitted len not match, was: %d", reopen.Len())
+	}
+	off, data, err := reopen.Next()
+	if nil != err || 3 != off || "message-3" != string(data) {
+		t.Fatalf("Recover read not match, offset: %d, data: %s, err: %s", off, data, err)
+	}
+	if off, err := reopen.Append([]byte("message-6")); nil != err || 6 != off {
+		t.Fatalf("Append after recover not match, offset: %d, err: %s", off, err)
+	}
+}
+
+func TestQueue_MaxBytes(t *testing.T) {
+	queue, dir := newTestQueue(t, Options{MaxBytes: 40})
+	defer os.RemoveAll(dir)
+	defer queue.Close()
+
+	data := []byte("0123456789")
+	queue.Append(data)
+	queue.Append(data)
+	if _, err := queue.Append(data); ErrFull != err {
+		t.Fatalf("Should be full, was: %s", err)
+	}
+	queue.Next()
+	queue.Next()
+	queue.Commit(1)
+	if _, err := queue.Append(data); nil != err {
+		t.Fatalf("Should release space after commit, was: %s", err)
+	}
+}
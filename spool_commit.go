@@ -0,0 +1,109 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline/spool"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 磁盘队列的确认位置：从磁盘读取的消息处理完成后才确认，确认位置只推进到连续完成的Offset
+//
+
+// 最小的未确认Offset超过此时间仍未完成时，输出警告
+const defaultCommitStallWarning = time.Second * 30
+
+type spoolCommitter struct {
+	name  string
+	queue *spool.Queue
+
+	mu      *sync.Mutex
+	started bool
+	next    uint64          // 最小的未确认Offset
+	done    map[uint64]bool // 已确认、但之前仍有未确认消息的Offset
+	stalls  bool            // 存在被放弃处理的消息时，不再推进确认位置，等待下次启动时恢复
+
+	since  time.Time     // 确认位置上次推进的时间
+	warned bool          // 当前确认位置已输出过阻塞警告
+	warnAt time.Duration // 确认位置阻塞超过此时间时输出警告
+}
+
+func newSpoolCommitter(name string, queue *spool.Queue) *spoolCommitter {
+	return &spoolCommitter{
+		name:  name,
+		queue: queue,
+		mu:     new(sync.Mutex),
+		done:   make(map[uint64]bool),
+		warnAt: defaultCommitStallWarning,
+	}
+}
+
+// 记录读取的消息Offset。第一个读取的Offset为确认的起始位置。
+func (slf *spoolCommitter) read(offset uint64) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	now := time.Now()
+	if !slf.started {
+		slf.next = offset
+		slf.started = true
+		slf.since = now
+		return
+	}
+	slf.checkStall(now)
+}
+
+// 设置消息的确认回调：处理完成后确认此Offset；Router停止时被放弃处理的消息，保留在磁盘中
+func (slf *spoolCommitter) track(pack *DataFrame, offset uint64) {
+	pack.SetAckHandler(func(err error) {
+		if ErrPipelineStopped == err {
+			slf.stall()
+			return
+		}
+		slf.commit(offset)
+	})
+}
+
+func (slf *spoolCommitter) stall() {
+	slf.mu.Lock()
+	slf.stalls = true
+	slf.mu.Unlock()
+}
+
+// 确认消息已处理完成。消息可能乱序完成，确认位置只推进到连续完成的Offset。
+func (slf *spoolCommitter) commit(offset uint64) {
+	slf.mu.Lock()
+	defer slf.mu.Unlock()
+	if slf.stalls {
+		return
+	}
+	slf.done[offset] = true
+	from := slf.next
+	for slf.done[slf.next] {
+		delete(slf.done, slf.next)
+		slf.next++
+	}
+	now := time.Now()
+	if from == slf.next {
+		slf.checkStall(now)
+		return
+	}
+	slf.since, slf.warned = now, false
+	if err := slf.queue.Commit(slf.next - 1); nil != err && spool.ErrClosed != err {
+		withTag(log.Error).Err(err).Msgf("Commit %s frame FAILED, offset: %d", slf.name, slf.next-1)
+	}
+}
+
+// 最小的未确认消息一直未完成时，后续消息完成后也无法确认，重启时将被重新处理。
+// 阻塞超过 warnAt 时输出一次警告，便于定位未完成的消息。
+func (slf *spoolCommitter) checkStall(now time.Time) {
+	if slf.warned || slf.stalls || 0 == len(slf.done) {
+		return
+	}
+	if waits := now.Sub(slf.since); waits >= slf.warnAt {
+		slf.warned = true
+		withTag(log.Warn).Msgf("Commit %s frames STALLED, offset: %d not completed in %s, completed after it: %d",
+			slf.name, slf.next, waits, len(slf.done))
+	}
+}
@@ -1,6 +1,7 @@
 package sql
 
 import (
+	"errors"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/parkingwang/go-conf"
@@ -9,6 +10,7 @@ import (
 	"github.com/yoojia/go-pipeline/abc"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,6 +25,8 @@ type GoPLMySQLQueryInput struct {
 	queryLimitEnd    time.Time     // 结束时间
 	queryTimeSection time.Duration // 查询时间距离
 	queryTimestamp   time.Time     // 查询当前时间
+
+	dbError *atomic.Value // 数据库连接错误信息，已连接时为空字符串
 }
 
 func (slf *GoPLMySQLQueryInput) Init(args conf.Map) {
@@ -31,6 +35,7 @@ func (slf *GoPLMySQLQueryInput) Init(args conf.Map) {
 	slf.interval = args.MustDuration("interval")
 	slf.dataSource = args.MustString("db_data_source")
 	slf.querySQL = args.MustString("db_query_sql")
+	slf.dbError = new(atomic.Value)
 
 	start := args.MustString("db_query_start")
 	if "" != start {
@@ -77,6 +82,7 @@ func (slf *GoPLMySQLQueryInput) Input(deliverer gopl.Deliverer, decoder gopl.Dec
 		slf.TagLog(log.Panic).Err(err).Msgf("Database connection failed: %s", slf.dataSource)
 	} else {
 		slf.TagLog(log.Info).Msgf("Database connected")
+		slf.dbError.Store("")
 	}
 	defer db.Close()
 
@@ -93,8 +99,10 @@ func (slf *GoPLMySQLQueryInput) Input(deliverer gopl.Deliverer, decoder gopl.Dec
 					slf.TagLog(log.Info).Msgf("Database reconnecting")
 					if ndb, err := sqlx.Connect("mysql", slf.dataSource); nil != err {
 						slf.TagLog(log.Error).Err(err).Msg(err.Error())
+						slf.dbError.Store(err.Error())
 					} else {
 						slf.TagLog(log.Info).Msgf("Database reconnected")
+						slf.dbError.Store("")
 						db = ndb
 					}
 				}
@@ -105,6 +113,16 @@ func (slf *GoPLMySQLQueryInput) Input(deliverer gopl.Deliverer, decoder gopl.Dec
 	}
 }
 
+// 检查数据库连接状态。重连失败时报告为不健康。
+func (slf *GoPLMySQLQueryInput) CheckHealth() error {
+	if msg, ok := slf.dbError.Load().(string); !ok {
+		return errors.New("database is not connected")
+	} else if "" != msg {
+		return errors.New("database reconnect failed: " + msg)
+	}
+	return nil
+}
+
 func (slf *GoPLMySQLQueryInput) queryInRange(db *sqlx.DB, deliverer gopl.Deliverer, decoder gopl.Decoder) error {
 	query, next := slf.nextQuerySQL()
 	slf.TagLog(log.Info).Str("sql", query).Msg("Executing SQL")
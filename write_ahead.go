@@ -0,0 +1,150 @@
+package gopl
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/yoojia/go-pipeline/spool"
+	"io"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+// 预写日志：Input投递的消息先同步写入磁盘，再由Router读取处理；消息处理完成后截断日志。
+// 进程崩溃时，未处理完成的消息在下次启动时恢复。
+//
+
+type writeAhead struct {
+	queue     *spool.Queue
+	retention time.Duration // 消息的最长保留时间，0表示不限制
+	handler   func(pack *DataFrame)
+	counter   *FioCounter
+	committer *spoolCommitter
+
+	stop chan struct{}
+	wg   *sync.WaitGroup
+}
+
+// 创建预写日志。未配置 wal_dir 时返回nil。
+func newWriteAhead(config RouterConfig, counter *FioCounter) *writeAhead {
+	if "" == config.WalDir {
+		return nil
+	}
+	queue, err := spool.Open(config.WalDir, spool.Options{
+		SegmentBytes: config.WalSegmentBytes,
+		MaxBytes:     config.WalMaxBytes,
+		SyncWrite:    true,
+	})
+	if nil != err {
+		withTag(log.Panic).Err(err).Msgf("Failed to open wal dir: %s", config.WalDir)
+	}
+	if remains := queue.Len(); 0 < remains {
+		withTag(log.Info).Msgf("Recover write-ahead frames: %d, dir: %s", remains, config.WalDir)
+	}
+	return &writeAhead{
+		queue:     queue,
+		retention: DurationValue(config.WalRetention),
+		counter:   counter,
+		committer: newSpoolCommitter("write-ahead", queue),
+		stop:      make(chan struct{}),
+		wg:        new(sync.WaitGroup),
+	}
+}
+
+// 启动读取协程，按写入顺序将消息派发给Router
+func (slf *writeAhead) start(handler func(pack *DataFrame)) {
+	slf.handler = handler
+	slf.wg.Add(1)
+	go slf.consume()
+}
+
+// 写入消息。写入磁盘后消息即被确认并释放；日志已满或者写入失败时，消息被拒绝，返回 ErrDeliverRejected
+func (slf *writeAhead) append(pack *DataFrame) error {
+	data, err := encodeDataFrame(pack)
+	if nil == err {
+		_, err = slf.queue.Append(data)
+	}
+	if nil != err {
+		if spool.ErrFull != err {
+			withTag(log.Error).Err(err).Msgf("Write-ahead frame FAILED, sender: %s", pack.Sender())
+		}
+		pack.fail(ErrDeliverRejected)
+		slf.counter.increaseRejected()
+		releaseDataFrame(pack)
+		return ErrDeliverRejected
+	}
+	releaseDataFrame(pack)
+	return nil
+}
+
+func (slf *writeAhead) consume() {
+	defer slf.wg.Done()
+	for {
+		select {
+		case <-slf.stop:
+			return
+		default:
+		}
+		offset, data, err := slf.queue.Next()
+		if io.EOF == err {
+			select {
+			case <-slf.stop:
+				return
+			case <-slf.queue.Signal():
+			}
+			continue
+		}
+		if nil != err {
+			withTag(log.Error).Err(err).Msg("Read write-ahead frame FAILED")
+			return
+		}
+		slf.committer.read(offset)
+		pack, err := decodeDataFrame(data)
+		if nil != err {
+			withTag(log.Error).Err(err).Msgf("Decode write-ahead frame FAILED, offset: %d", offset)
+			slf.committer.commit(offset)
+			continue
+		}
+		if slf.isOutdated(pack, time.Now()) {
+			withTag(log.Warn).Msgf("Write-ahead frame OUTDATED, offset: %d, sender: %s", offset, pack.Sender())
+			slf.counter.increaseExpired()
+			releaseDataFrame(pack)
+			slf.committer.commit(offset)
+			continue
+		}
+		// 消息处理完成后确认；Router停止时被放弃处理的消息，保留在日志中
+		slf.committer.track(pack, offset)
+		slf.handler(pack)
+	}
+}
+
+// 消息写入日志的时间超过保留时间。写入时间为Input接收消息的时间。
+func (slf *writeAhead) isOutdated(pack *DataFrame, now time.Time) bool {
+	if 0 >= slf.retention {
+		return false
+	}
+	traces := pack.Traces()
+	if 0 == len(traces) {
+		return false
+	}
+	return now.Sub(time.Unix(0, traces[0].Timestamp)) > slf.retention
+}
+
+// 停止读取日志。已派发的消息继续处理，未读取的消息保留在日志中。
+func (slf *writeAhead) stopConsume() {
+	select {
+	case <-slf.stop:
+	default:
+		close(slf.stop)
+	}
+	slf.wg.Wait()
+}
+
+// 关闭日志文件
+func (slf *writeAhead) close() {
+	slf.stopConsume()
+	if remains := slf.queue.Len(); 0 < remains {
+		withTag(log.Info).Msgf("Write-ahead frames remains: %d, will recover on next startup", remains)
+	}
+	slf.queue.Close()
+}
@@ -0,0 +1,218 @@
+package gopl
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈永佳 chenyongjia@parkingwang.com, yoojiachen@gmail.com
+//
+
+func newTestWalFrame(topic string) *DataFrame {
+	pack := NewDataFrame()
+	pack.setTopic(topic)
+	pack.addTrace("TestInput", time.Now().UnixNano())
+	pack.SetBody(bytes.NewBufferString(topic))
+	return pack
+}
+
+func TestWriteAhead_CommitAndRecover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal := newWriteAhead(RouterConfig{WalDir: dir}, new(FioCounter))
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		if err := wal.append(newTestWalFrame(topic)); nil != err {
+			t.Fatalf("Should append, was: %s", err)
+		}
+	}
+	received := make(chan *DataFrame, 3)
+	wal.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	frames := make([]*DataFrame, 0)
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		select {
+		case pack := <-received:
+			if topic != pack.Topic() {
+				t.Fatalf("Frame order not match, was: %s", pack.Topic())
+			}
+			frames = append(frames, pack)
+		case <-time.After(time.Second):
+			t.Fatalf("Read frame timeout, topic: %s", topic)
+		}
+	}
+	// 乱序完成：/b 完成时确认位置不推进
+	releaseDataFrame(frames[1])
+	if 3 != wal.queue.Len() {
+		t.Fatalf("Uncommitted frames not match, was: %d", wal.queue.Len())
+	}
+	releaseDataFrame(frames[0])
+	if 1 != wal.queue.Len() {
+		t.Fatalf("Uncommitted frames not match, was: %d", wal.queue.Len())
+	}
+	wal.close()
+
+	// 未确认的消息在重新打开时恢复
+	wal = newWriteAhead(RouterConfig{WalDir: dir}, new(FioCounter))
+	defer wal.close()
+	wal.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	select {
+	case pack := <-received:
+		body, _ := pack.ReadBytes()
+		if "/c" != pack.Topic() || "/c" != string(body) {
+			t.Fatalf("Recovered frame not match, topic: %s", pack.Topic())
+		}
+		releaseDataFrame(pack)
+	case <-time.After(time.Second):
+		t.Fatal("Recover frame timeout")
+	}
+}
+
+func TestWriteAhead_StoppedNotCommitted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal := newWriteAhead(RouterConfig{WalDir: dir}, new(FioCounter))
+	defer wal.close()
+	wal.append(newTestWalFrame("/a"))
+	received := make(chan *DataFrame, 1)
+	wal.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	pack := <-received
+	pack.fail(ErrPipelineStopped)
+	releaseDataFrame(pack)
+	if 1 != wal.queue.Len() {
+		t.Fatalf("Stopped frame should remain, was: %d", wal.queue.Len())
+	}
+}
+
+func TestWriteAhead_Retention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	counter := new(FioCounter)
+	wal := newWriteAhead(RouterConfig{WalDir: dir, WalRetention: "1m"}, counter)
+	defer wal.close()
+	outdated := NewDataFrame()
+	outdated.addTrace("TestInput", time.Now().Add(-time.Hour).UnixNano())
+	wal.append(outdated)
+	wal.append(newTestWalFrame("/fresh"))
+
+	received := make(chan *DataFrame, 2)
+	wal.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	select {
+	case pack := <-received:
+		if "/fresh" != pack.Topic() {
+			t.Fatalf("Outdated frame should be dropped, was: %s", pack.Topic())
+		}
+		releaseDataFrame(pack)
+	case <-time.After(time.Second):
+		t.Fatal("Read frame timeout")
+	}
+	if 1 != counter.Expired() || 0 != wal.queue.Len() {
+		t.Fatalf("Outdated frame not committed, expired: %d, remains: %d", counter.Expired(), wal.queue.Len())
+	}
+}
+
+func TestWriteAhead_Full(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	counter := new(FioCounter)
+	wal := newWriteAhead(RouterConfig{WalDir: dir, WalMaxBytes: 64}, counter)
+	defer wal.close()
+	recorder := new(testAckRecorder)
+	pack := newTestWalFrame("/large")
+	pack.SetAckHandler(recorder.handler)
+	pack.SetBody(bytes.NewReader(make([]byte, 128)))
+	if err := wal.append(pack); ErrDeliverRejected != err {
+		t.Fatalf("Should reject, was: %v", err)
+	}
+	if acks := recorder.results(); 1 != len(acks) || ErrDeliverRejected != acks[0] || 1 != counter.Rejected() {
+		t.Fatalf("Rejected frame should be nacked, was: %v", acks)
+	}
+}
+
+func TestWriteAhead_ReplayThroughIngress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	router := newRouter(1)
+	router.ingress = newIngress(RouterConfig{Backpressure: BackpressureReject, MaxPending: 1}, router.fio, false)
+	router.wal = newWriteAhead(RouterConfig{WalDir: dir}, router.fio)
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		router.wal.append(newTestWalFrame(topic))
+	}
+	// 入口队列容量为1，派发协程逐个处理消息
+	received := make(chan *DataFrame)
+	router.ingress.start(func(pack *DataFrame) {
+		received <- pack
+	})
+	router.wal.start(router.replayWriteAhead)
+	for _, topic := range []string{"/a", "/b", "/c"} {
+		select {
+		case pack := <-received:
+			if topic != pack.Topic() {
+				t.Fatalf("Frame order not match, was: %s", pack.Topic())
+			}
+			releaseDataFrame(pack)
+		case <-time.After(time.Second):
+			t.Fatalf("Read frame timeout, topic: %s", topic)
+		}
+	}
+	if 0 != router.fio.Rejected() {
+		t.Fatalf("Write-ahead frames should not be rejected, was: %d", router.fio.Rejected())
+	}
+	router.wal.close()
+	router.ingress.close()
+}
+
+func TestSpoolCommitter_StallWarning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gopl-wal")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	wal := newWriteAhead(RouterConfig{WalDir: dir}, new(FioCounter))
+	defer wal.close()
+	committer := wal.committer
+	committer.warnAt = time.Millisecond
+	committer.read(0)
+	committer.read(1)
+	time.Sleep(time.Millisecond * 5)
+	// Offset 0 未完成，Offset 1 完成后确认位置无法推进
+	committer.commit(1)
+	if !committer.warned {
+		t.Fatal("Stalled commit should be warned")
+	}
+	committer.commit(0)
+	if committer.warned || 2 != committer.next {
+		t.Fatalf("Commit should advance, next: %d", committer.next)
+	}
+}
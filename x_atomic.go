@@ -29,3 +29,23 @@ func (slf *AtomicBoolean) Set(flag bool) {
 	}
 	atomic.StoreUint32(&slf.flag, val)
 }
+
+////
+
+type AtomicInt64 struct {
+	value int64
+}
+
+func NewAtomicInt64() *AtomicInt64 {
+	return &AtomicInt64{
+		value: int64(0),
+	}
+}
+
+func (slf *AtomicInt64) Get() int64 {
+	return atomic.LoadInt64(&slf.value)
+}
+
+func (slf *AtomicInt64) Add(delta int64) int64 {
+	return atomic.AddInt64(&slf.value, delta)
+}
@@ -1,6 +1,8 @@
 package gopl
 
-import "sync/atomic"
+import (
+	"sync/atomic"
+)
 
 //
 // Author: 陈哈哈 chenyongjia@parkingwang.com, yoojiachen@gmail.com
@@ -11,6 +13,11 @@ type FioCounter struct {
 	InCount  uint64
 	OutCount uint64
 	FilCount uint64
+	ExpCount uint64
+	RejCount uint64
+	SplCount uint64
+	DlqCount uint64
+	ThrCount uint64
 }
 
 func (slf *FioCounter) Inbounds() uint64 {
@@ -25,27 +32,220 @@ func (slf *FioCounter) Filtered() uint64 {
 	return atomic.LoadUint64(&slf.FilCount)
 }
 
-var gFioCounter = &FioCounter{}
+// Expired 返回超过截止时间而被放弃处理的消息数量
+func (slf *FioCounter) Expired() uint64 {
+	return atomic.LoadUint64(&slf.ExpCount)
+}
+
+// Rejected 返回按背压策略被拒绝或者丢弃的消息数量
+func (slf *FioCounter) Rejected() uint64 {
+	return atomic.LoadUint64(&slf.RejCount)
+}
+
+// Spilled 返回按背压策略写入磁盘队列的消息数量
+func (slf *FioCounter) Spilled() uint64 {
+	return atomic.LoadUint64(&slf.SplCount)
+}
+
+// DeadLetters 返回投递到死信Topic的消息数量
+func (slf *FioCounter) DeadLetters() uint64 {
+	return atomic.LoadUint64(&slf.DlqCount)
+}
+
+// Throttled 返回被Input或者Output限流丢弃的消息数量
+func (slf *FioCounter) Throttled() uint64 {
+	return atomic.LoadUint64(&slf.ThrCount)
+}
 
 // 重置统计数据
-func resetFioCounter() {
-	atomic.StoreUint64(&gFioCounter.InCount, 0)
-	atomic.StoreUint64(&gFioCounter.FilCount, 0)
-	atomic.StoreUint64(&gFioCounter.OutCount, 0)
+func (slf *FioCounter) reset() {
+	atomic.StoreUint64(&slf.InCount, 0)
+	atomic.StoreUint64(&slf.FilCount, 0)
+	atomic.StoreUint64(&slf.OutCount, 0)
+	atomic.StoreUint64(&slf.ExpCount, 0)
+	atomic.StoreUint64(&slf.RejCount, 0)
+	atomic.StoreUint64(&slf.SplCount, 0)
+	atomic.StoreUint64(&slf.DlqCount, 0)
+	atomic.StoreUint64(&slf.ThrCount, 0)
+}
+
+func (slf *FioCounter) increaseInbound() {
+	atomic.AddUint64(&slf.InCount, 1)
+}
+
+func (slf *FioCounter) increaseFilter() {
+	atomic.AddUint64(&slf.FilCount, 1)
+}
+
+func (slf *FioCounter) increaseOutbounds() {
+	atomic.AddUint64(&slf.OutCount, 1)
+}
+
+func (slf *FioCounter) increaseExpired() {
+	atomic.AddUint64(&slf.ExpCount, 1)
+}
+
+func (slf *FioCounter) increaseRejected() {
+	atomic.AddUint64(&slf.RejCount, 1)
 }
 
-func increaseInbound() {
-	atomic.AddUint64(&gFioCounter.InCount, 1)
+func (slf *FioCounter) increaseSpilled() {
+	atomic.AddUint64(&slf.SplCount, 1)
 }
 
-func increaseFilter() {
-	atomic.AddUint64(&gFioCounter.FilCount, 1)
+func (slf *FioCounter) increaseDeadLetter() {
+	atomic.AddUint64(&slf.DlqCount, 1)
 }
 
-func increaseOutbounds() {
-	atomic.AddUint64(&gFioCounter.OutCount, 1)
+func (slf *FioCounter) increaseThrottled() {
+	atomic.AddUint64(&slf.ThrCount, 1)
 }
 
+// GetFioCounter 返回默认Pipeline实例的消息数据统计
 func GetFioCounter() *FioCounter {
-	return gFioCounter
+	return SharedRouter().FioCounter()
+}
+
+////
+
+// Output消息队列统计
+type QueueCounter struct {
+	Name     string // Output组件名称
+	Capacity int    // 队列容量
+
+	depth   func() int
+	dropped uint64
+}
+
+func newQueueCounter(name string, capacity int, depth func() int) *QueueCounter {
+	counter := &QueueCounter{
+		Name:     name,
+		Capacity: capacity,
+		depth:    depth,
+	}
+	return counter
+}
+
+// Depth 返回队列中等待处理的消息数量
+func (slf *QueueCounter) Depth() int {
+	return slf.depth()
+}
+
+// Dropped 返回队列已满时被丢弃的消息数量
+func (slf *QueueCounter) Dropped() uint64 {
+	return atomic.LoadUint64(&slf.dropped)
+}
+
+func (slf *QueueCounter) increaseDropped() {
+	atomic.AddUint64(&slf.dropped, 1)
+}
+
+// GetQueueCounters 返回默认Pipeline实例中，所有启用了独立队列的Output的队列统计
+func GetQueueCounters() []*QueueCounter {
+	return SharedRouter().QueueCounters()
+}
+
+////
+
+// 组件消息处理统计
+type ComponentCounter struct {
+	Name string // 组件名称
+
+	handled uint64
+	errors  uint64
+	dropped uint64
+	retries uint64
+	broken  uint64
+	limited uint64
+	spooled uint64
+
+	circuit *atomic.Value // Output熔断器状态
+}
+
+func newComponentCounter(name string) *ComponentCounter {
+	counter := &ComponentCounter{
+		Name:    name,
+		circuit: new(atomic.Value),
+	}
+	return counter
+}
+
+// Handled 返回组件成功处理的消息数量
+func (slf *ComponentCounter) Handled() uint64 {
+	return atomic.LoadUint64(&slf.handled)
+}
+
+// Errors 返回组件处理消息时返回错误的数量
+func (slf *ComponentCounter) Errors() uint64 {
+	return atomic.LoadUint64(&slf.errors)
+}
+
+// Dropped 返回Filter丢弃的消息数量
+func (slf *ComponentCounter) Dropped() uint64 {
+	return atomic.LoadUint64(&slf.dropped)
+}
+
+// Retries 返回Output重试处理消息的次数
+func (slf *ComponentCounter) Retries() uint64 {
+	return atomic.LoadUint64(&slf.retries)
+}
+
+// ShortCircuited 返回Output熔断期间被快速放弃的消息数量
+func (slf *ComponentCounter) ShortCircuited() uint64 {
+	return atomic.LoadUint64(&slf.broken)
+}
+
+// Throttled 返回Output限流丢弃的消息数量
+func (slf *ComponentCounter) Throttled() uint64 {
+	return atomic.LoadUint64(&slf.limited)
+}
+
+// Spooled 返回下游不可用时，写入Output磁盘暂存的消息数量
+func (slf *ComponentCounter) Spooled() uint64 {
+	return atomic.LoadUint64(&slf.spooled)
+}
+
+// CircuitState 返回Output熔断器的状态：closed/open/half_open。未配置熔断器时返回空字符串
+func (slf *ComponentCounter) CircuitState() string {
+	if state, ok := slf.circuit.Load().(string); ok {
+		return state
+	}
+	return ""
+}
+
+func (slf *ComponentCounter) setCircuitState(state string) {
+	slf.circuit.Store(state)
+}
+
+func (slf *ComponentCounter) increaseHandled() {
+	atomic.AddUint64(&slf.handled, 1)
+}
+
+func (slf *ComponentCounter) increaseErrors() {
+	atomic.AddUint64(&slf.errors, 1)
+}
+
+func (slf *ComponentCounter) increaseDropped() {
+	atomic.AddUint64(&slf.dropped, 1)
+}
+
+func (slf *ComponentCounter) increaseRetries() {
+	atomic.AddUint64(&slf.retries, 1)
+}
+
+func (slf *ComponentCounter) increaseShortCircuited() {
+	atomic.AddUint64(&slf.broken, 1)
+}
+
+func (slf *ComponentCounter) increaseThrottled() {
+	atomic.AddUint64(&slf.limited, 1)
+}
+
+func (slf *ComponentCounter) increaseSpooled() {
+	atomic.AddUint64(&slf.spooled, 1)
+}
+
+// GetComponentCounters 返回默认Pipeline实例中，所有Filter和Output组件的消息处理统计
+func GetComponentCounters() []*ComponentCounter {
+	return SharedRouter().ComponentCounters()
 }
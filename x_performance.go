@@ -14,22 +14,33 @@ type DataFrameSamples struct {
 	FilteredSamples *IntSamples
 }
 
-var gPerformanceSamples = DataFrameSamples{
-	InboundSamples:  NewIntSamples(MaxSamplesCountPerformanceAvg),
-	OutboundSamples: NewIntSamples(MaxSamplesCountPerformanceAvg),
-	FilteredSamples: NewIntSamples(MaxSamplesCountPerformanceAvg),
+func newDataFrameSamples() *DataFrameSamples {
+	return &DataFrameSamples{
+		InboundSamples:  NewIntSamples(MaxSamplesCountPerformanceAvg),
+		OutboundSamples: NewIntSamples(MaxSamplesCountPerformanceAvg),
+		FilteredSamples: NewIntSamples(MaxSamplesCountPerformanceAvg),
+	}
 }
 
-func sampleInbound(du int64) {
-	gPerformanceSamples.InboundSamples.AddSample(du)
+func (slf *DataFrameSamples) sampleInbound(du int64) {
+	slf.InboundSamples.AddSample(du)
 }
 
-func sampleFilter(du int64) {
-	gPerformanceSamples.FilteredSamples.AddSample(du)
+func (slf *DataFrameSamples) sampleFilter(du int64) {
+	slf.FilteredSamples.AddSample(du)
 }
 
-func sampleOutbounds(du int64) {
-	gPerformanceSamples.OutboundSamples.AddSample(du)
+func (slf *DataFrameSamples) sampleOutbounds(du int64) {
+	slf.OutboundSamples.AddSample(du)
+}
+
+// Avg 返回消息处理耗时的平均值
+func (slf *DataFrameSamples) Avg() AvgSamples {
+	return AvgSamples{
+		InboundsAvg:  slf.InboundSamples.Avg(),
+		OutboundsAvg: slf.OutboundSamples.Avg(),
+		FilteredAvg:  slf.FilteredSamples.Avg(),
+	}
 }
 
 type AvgSamples struct {
@@ -38,10 +49,7 @@ type AvgSamples struct {
 	FilteredAvg  int64
 }
 
+// TakeDataFramesAvgSamples 返回默认Pipeline实例的消息处理耗时平均值
 func TakeDataFramesAvgSamples() AvgSamples {
-	return AvgSamples{
-		InboundsAvg:  gPerformanceSamples.InboundSamples.Avg(),
-		OutboundsAvg: gPerformanceSamples.OutboundSamples.Avg(),
-		FilteredAvg:  gPerformanceSamples.FilteredSamples.Avg(),
-	}
+	return SharedRouter().Samples().Avg()
 }